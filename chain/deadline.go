@@ -0,0 +1,37 @@
+package chain
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// XRequestTimeout 调用方剩余超时预算, 单位毫秒
+// 传相对时长而不是绝对时间, 避免不同机器时钟偏差
+const XRequestTimeout = "X-Request-Timeout"
+
+// DeadlineMargin 默认安全余量, 抵扣网络传输和响应回写耗时
+const DeadlineMargin = 50 * time.Millisecond
+
+// SetDeadlineHeader ctx 存在 deadline 时把剩余时长写入 header, 已经设置过的不覆盖
+func SetDeadlineHeader(ctx context.Context, header http.Header) {
+	deadline, ok := ctx.Deadline()
+	if !ok || header.Get(XRequestTimeout) != "" {
+		return
+	}
+	header.Set(XRequestTimeout, strconv.FormatInt(max(0, time.Until(deadline).Milliseconds()), 10))
+}
+
+// WithDeadlineHeader 把 header 中的剩余预算减去 margin 转换成 ctx deadline, 只会缩短不会延长原有 deadline
+// header 缺失或非法时原样返回 ctx; 预算已经用完时返回的 ctx 立即超时
+func WithDeadlineHeader(ctx context.Context, header http.Header, margin time.Duration) (context.Context, context.CancelFunc) {
+	budget, err := strconv.ParseInt(header.Get(XRequestTimeout), 10, 64)
+	if err != nil || budget < 0 {
+		return ctx, func() {}
+	}
+	budget = min(budget, int64(math.MaxInt64/time.Millisecond))
+
+	return context.WithTimeout(ctx, time.Duration(budget)*time.Millisecond-margin)
+}
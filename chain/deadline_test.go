@@ -0,0 +1,44 @@
+package chain
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDeadlineHeader(t *testing.T) {
+	header := http.Header{}
+	SetDeadlineHeader(context.Background(), header)
+	if header.Get(XRequestTimeout) != "" {
+		t.Fatal("no deadline should not set header")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	SetDeadlineHeader(ctx, header)
+	budget, err := strconv.Atoi(header.Get(XRequestTimeout))
+	if err != nil || budget <= 1900 || budget > 2000 {
+		t.Fatalf("budget = %q", header.Get(XRequestTimeout))
+	}
+
+	serverctx, servercancel := WithDeadlineHeader(context.Background(), header, 500*time.Millisecond)
+	defer servercancel()
+	deadline, ok := serverctx.Deadline()
+	if left := time.Until(deadline); !ok || left > 1500*time.Millisecond || left < 1300*time.Millisecond {
+		t.Fatalf("server deadline left %v", left)
+	}
+
+	header.Set(XRequestTimeout, "10")
+	expired, expiredcancel := WithDeadlineHeader(context.Background(), header, 50*time.Millisecond)
+	defer expiredcancel()
+	if expired.Err() == nil {
+		t.Fatal("exhausted budget should expire immediately")
+	}
+
+	header.Set(XRequestTimeout, "bad")
+	if same, _ := WithDeadlineHeader(ctx, header, 0); same != ctx {
+		t.Fatal("invalid header should return ctx as is")
+	}
+}
@@ -3,6 +3,7 @@ package rediser
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"time"
 )
@@ -58,3 +59,37 @@ func (rate *Limiter) Allow(ctx context.Context) bool {
 
 	return true
 }
+
+// takeScript INCR 与 PEXPIRE 放在一个脚本里原子执行, 避免 Incr 成功 Expire 失败留下永不过期的 key
+var takeScript = `
+local count = redis.call("INCR", KEYS[1])
+local ttl = redis.call("PTTL", KEYS[1])
+if count == 1 or ttl < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+return {count, ttl}
+`
+
+// Take 和 Allow 一样按固定窗口计数, 额外返回当前计数和窗口剩余时间, 方便 HTTP 层输出限流 header
+// count > rate.N 表示已被限流
+func (rate *Limiter) Take(ctx context.Context) (count int64, reset time.Duration, err error) {
+	result, err := rate.R.Eval(ctx, takeScript, []string{rate.Key}, rate.TTL.Milliseconds())
+	if err != nil {
+		return
+	}
+
+	values, ok := result.([]any)
+	if !ok || len(values) != 2 {
+		slog.ErrorContext(ctx, "Limiter Take result panic error", slog.String("key", rate.Key), slog.Any("result", result))
+		return 0, 0, fmt.Errorf("rediser: unexpected limiter result %v", result)
+	}
+	count, _ = values[0].(int64)
+	ttl, _ := values[1].(int64)
+	reset = time.Duration(ttl) * time.Millisecond
+
+	if count > rate.N {
+		slog.InfoContext(ctx, "Rate limit exceeded", slog.String("key", rate.Key), slog.Int64("count", count))
+	}
+	return
+}
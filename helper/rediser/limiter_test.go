@@ -21,3 +21,18 @@ func TestLimiter_Allow(t *testing.T) {
 	time.Sleep(time.Second)
 	t.Log(rate.Allow(ctx)) // true
 }
+
+func TestLimiter_Take(t *testing.T) {
+	r := requireRedis(t)
+
+	rate := NewLimiter(r, "mykey:take", 3*time.Second, 1)
+	defer r.Del(ctx, rate.Key)
+
+	for range 3 {
+		count, reset, err := rate.Take(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Log(count, reset) // 1 3s, 2 3s, 3 3s
+	}
+}
@@ -0,0 +1,35 @@
+// Package redistest provides shared helpers for Redis integration tests.
+package redistest
+
+import (
+	"context"
+	"os"
+	"testing"
+)
+
+// Command 测试 Redis 连接参数, 优先读取环境变量 SBP_TEST_REDIS, 方便在 CI 或本机显式指定
+// 未配置时回退到 redis-cli 默认参数, 对应 localhost:6379
+func Command() string {
+	if command := os.Getenv("SBP_TEST_REDIS"); command != "" {
+		return command
+	}
+	return "redis-cli"
+}
+
+// Require Redis 集成测试前置检查, 没有可用 Redis 时跳过测试, 避免默认 go test ./... 因外部依赖失败
+// 连接在测试结束 (t.Cleanup) 时关闭; 不直接依赖 rediser, rediser 包自己的测试也可以使用
+//
+//	r := redistest.Require(t, rediser.NewDefaultRedis)
+func Require[C interface{ Close(context.Context) error }](t testing.TB, connect func(ctx context.Context, command string) (C, error)) C {
+	t.Helper()
+
+	ctx := context.Background()
+	r, err := connect(ctx, Command())
+	if err != nil {
+		t.Skipf("skip redis integration test: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = r.Close(ctx)
+	})
+	return r
+}
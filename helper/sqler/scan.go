@@ -0,0 +1,354 @@
+package sqler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Queryer *DB / *sql.DB / *sql.Tx / *sql.Conn 都满足, Get / Select 可以在事务中使用
+type Queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// QueryContext 同 sql.DB.QueryContext, 让 *DB 满足 Queryer
+func (s *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return s.DB().QueryContext(ctx, query, args...)
+}
+
+// Get 查询单条记录映射为 T, 没有记录返回 sql.ErrNoRows
+// T 为 struct 时按 db tag 映射列, 否则 (int / string / time.Time / sql.Scanner 等) 要求结果只有一列
+//
+//	type User struct {
+//		ID        int64          `db:"id"`
+//		Name      sql.NullString `db:"name"`
+//		Extra     map[string]any `db:"extra,json"` // JSON 列
+//		CreatedAt time.Time      // 没有 tag 按 snake_case 映射为 created_at
+//		Base                     // 匿名嵌入 struct 的字段展开映射
+//	}
+//	user, err := sqler.Get[User](ctx, db, "SELECT * FROM user WHERE id = ?", id)
+func Get[T any](ctx context.Context, q Queryer, query string, args ...any) (obj T, err error) {
+	defer After(ctx, Before(ctx, query, args))
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		slog.ErrorContext(ctx, "SQLer Get QueryContext error", "query", query, "args", args, "error", err)
+		return
+	}
+	defer rows.Close()
+
+	plan, err := newScanPlan[T](rows)
+	if err != nil {
+		slog.ErrorContext(ctx, "SQLer Get scan plan error", "query", query, "args", args, "error", err)
+		return
+	}
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			slog.ErrorContext(ctx, "SQLer Get rows.Err() error", "query", query, "args", args, "error", err)
+			return
+		}
+		// 没有找到记录，返回 sql.ErrNoRows 错误
+		slog.InfoContext(ctx, "SQLer Get no rows found", "query", query, "args", args)
+		return obj, sql.ErrNoRows
+	}
+
+	if err = plan.scan(rows, &obj); err != nil {
+		slog.ErrorContext(ctx, "SQLer Get rows.Scan error", "query", query, "args", args, "error", err)
+		return
+	}
+	return
+}
+
+// Select 查询多条记录映射为 []T, 映射规则同 Get, 没有记录返回空切片
+func Select[T any](ctx context.Context, q Queryer, query string, args ...any) (results []T, err error) {
+	defer After(ctx, Before(ctx, query, args))
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		slog.ErrorContext(ctx, "SQLer Select QueryContext error", "query", query, "args", args, "error", err)
+		return
+	}
+	defer rows.Close()
+
+	plan, err := newScanPlan[T](rows)
+	if err != nil {
+		slog.ErrorContext(ctx, "SQLer Select scan plan error", "query", query, "args", args, "error", err)
+		return
+	}
+
+	results = []T{}
+	for rows.Next() {
+		var obj T
+		if err = plan.scan(rows, &obj); err != nil {
+			slog.ErrorContext(ctx, "SQLer Select rows.Scan error", "query", query, "args", args, "error", err)
+			return nil, err
+		}
+		results = append(results, obj)
+	}
+
+	// 检查迭代过程中是否出错（在关闭 rows 之前检查）
+	if err = rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "SQLer Select rows.Err() error", "query", query, "args", args, "error", err)
+		return nil, err
+	}
+	return
+}
+
+// structField 一个可映射列对应的字段
+type structField struct {
+	index []int // reflect 字段路径, 匿名嵌入 struct 时多于一层
+	json  bool  // db:"name,json" 按 JSON 解析
+	time  bool  // time.Time / *time.Time, 兼容驱动返回字符串
+}
+
+var (
+	scannerType = reflect.TypeFor[sql.Scanner]()
+	timeType    = reflect.TypeFor[time.Time]()
+)
+
+// structFields 按类型缓存 列名 -> 字段, 反射解析 struct 只做一次
+var structFields sync.Map // reflect.Type -> *typeFields
+
+// typeFields 一个 struct 类型的解析结果
+type typeFields struct {
+	fields    map[string]structField
+	ambiguous map[string]bool // 同一深度出现多次的列名, 和 Go 字段提升一样不确定映射到哪个字段
+	err       error
+}
+
+// mappable T 是否按 struct 字段映射; time.Time 和 sql.Scanner (如 sql.NullString) 直接扫描
+func mappable(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != timeType && !reflect.PointerTo(t).Implements(scannerType)
+}
+
+func fieldsOf(t reflect.Type) *typeFields {
+	if fields, ok := structFields.Load(t); ok {
+		return fields.(*typeFields)
+	}
+
+	fields := &typeFields{fields: make(map[string]structField), ambiguous: make(map[string]bool)}
+	fields.err = collectFields(t, nil, fields, make(map[string]int))
+	actual, _ := structFields.LoadOrStore(t, fields)
+	return actual.(*typeFields)
+}
+
+// collectFields 深度优先收集字段, 同名列浅层字段优先, 同一深度重名视为歧义, 和 Go 字段提升规则一致
+func collectFields(t reflect.Type, index []int, fields *typeFields, depths map[string]int) error {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		tag, hasTag := field.Tag.Lookup("db")
+		if tag == "-" {
+			continue
+		}
+		name, option, _ := strings.Cut(tag, ",")
+
+		path := append(index[:len(index):len(index)], i)
+		ft := field.Type
+		if field.Anonymous && !hasTag {
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+				if mappable(ft) && !field.IsExported() {
+					// nil 时需要分配, 但反射不能给未导出字段赋值
+					return fmt.Errorf("sqler: embedded pointer to unexported struct %s in %s is not supported", ft, t)
+				}
+			}
+			if mappable(ft) {
+				if err := collectFields(ft, path, fields, depths); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		// 列名不区分大小写
+		if name == "" {
+			name = snakeCase(field.Name)
+		} else {
+			name = strings.ToLower(name)
+		}
+		if depth, ok := depths[name]; ok {
+			if depth < len(path) {
+				continue
+			}
+			if depth == len(path) {
+				fields.ambiguous[name] = true
+				continue
+			}
+		}
+		depths[name] = len(path)
+		delete(fields.ambiguous, name)
+		fields.fields[name] = structField{
+			index: path,
+			json:  option == "json",
+			time:  ft == timeType || (ft.Kind() == reflect.Pointer && ft.Elem() == timeType),
+		}
+	}
+	return nil
+}
+
+// snakeCase CreatedAt -> created_at, UserID -> user_id
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// scanPlan 一次查询的 列 -> 字段 映射
+type scanPlan struct {
+	fields []structField // nil 表示 T 不是 struct, 直接扫描单列
+}
+
+func newScanPlan[T any](rows *sql.Rows) (*scanPlan, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	t := reflect.TypeFor[T]()
+	if !mappable(t) {
+		if len(columns) != 1 {
+			return nil, fmt.Errorf("sqler: scan %d columns into %s, expected 1", len(columns), t)
+		}
+		return &scanPlan{}, nil
+	}
+
+	fields := fieldsOf(t)
+	if fields.err != nil {
+		return nil, fields.err
+	}
+	plan := &scanPlan{fields: make([]structField, len(columns))}
+	for i, column := range columns {
+		name := strings.ToLower(column)
+		if fields.ambiguous[name] {
+			return nil, fmt.Errorf("sqler: column %q matches multiple fields at the same depth in %s", column, t)
+		}
+		field, ok := fields.fields[name]
+		if !ok {
+			return nil, fmt.Errorf("sqler: column %q has no field in %s", column, t)
+		}
+		plan.fields[i] = field
+	}
+	return plan, nil
+}
+
+func (plan *scanPlan) scan(rows *sql.Rows, obj any) error {
+	if plan.fields == nil {
+		if t, ok := obj.(*time.Time); ok {
+			return rows.Scan(&timeScanner{reflect.ValueOf(t).Elem()})
+		}
+		return rows.Scan(obj)
+	}
+
+	v := reflect.ValueOf(obj).Elem()
+	dests := make([]any, len(plan.fields))
+	for i, field := range plan.fields {
+		fv := fieldByIndex(v, field.index)
+		switch {
+		case field.json:
+			dests[i] = &jsonScanner{fv}
+		case field.time:
+			dests[i] = &timeScanner{fv}
+		default:
+			dests[i] = fv.Addr().Interface()
+		}
+	}
+	return rows.Scan(dests...)
+}
+
+// fieldByIndex 同 reflect.Value.FieldByIndex, 遇到 nil 的嵌入指针时自动分配
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// jsonScanner JSON 列, NULL 保持零值
+type jsonScanner struct {
+	v reflect.Value
+}
+
+func (s *jsonScanner) Scan(src any) error {
+	var data []byte
+	switch val := src.(type) {
+	case nil:
+		s.v.SetZero()
+		return nil
+	case []byte:
+		data = val
+	case string:
+		data = []byte(val)
+	default:
+		return fmt.Errorf("sqler: unsupported JSON column type %T", src)
+	}
+	return json.Unmarshal(data, s.v.Addr().Interface())
+}
+
+// timeLayouts 驱动没有开启时间解析 (如 MySQL parseTime=false) 时返回的常见格式
+var timeLayouts = []string{"2006-01-02 15:04:05.999999999", time.RFC3339Nano, time.DateOnly}
+
+// timeScanner time.Time / *time.Time 字段, 兼容驱动返回 []byte / string, NULL 保持零值
+type timeScanner struct {
+	v reflect.Value
+}
+
+func (s *timeScanner) Scan(src any) error {
+	switch val := src.(type) {
+	case nil:
+		s.v.SetZero()
+		return nil
+	case time.Time:
+		s.set(val)
+		return nil
+	case []byte:
+		return s.parse(string(val))
+	case string:
+		return s.parse(val)
+	}
+	return fmt.Errorf("sqler: unsupported time column type %T", src)
+}
+
+func (s *timeScanner) parse(text string) (err error) {
+	for _, layout := range timeLayouts {
+		var value time.Time
+		if value, err = time.ParseInLocation(layout, text, time.Local); err == nil {
+			s.set(value)
+			return nil
+		}
+	}
+	return fmt.Errorf("sqler: parse time %q: %w", text, err)
+}
+
+func (s *timeScanner) set(value time.Time) {
+	if s.v.Kind() == reflect.Pointer {
+		s.v.Set(reflect.ValueOf(&value))
+	} else {
+		s.v.Set(reflect.ValueOf(value))
+	}
+}
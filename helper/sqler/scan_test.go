@@ -0,0 +1,187 @@
+package sqler
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/wangzhione/sbp/chain"
+)
+
+// fakeDriver 按 query 返回固定结果集, 只用于测试列映射
+type fakeDriver struct{}
+
+type fakeResult struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+var fakeResults = map[string]fakeResult{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{query}, nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+type fakeStmt struct{ query string }
+
+func (fakeStmt) Close() error                               { return nil }
+func (fakeStmt) NumInput() int                              { return -1 }
+func (fakeStmt) Exec([]driver.Value) (driver.Result, error) { return nil, errors.New("not supported") }
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	result, ok := fakeResults[s.query]
+	if !ok {
+		return nil, errors.New("unknown query " + s.query)
+	}
+	return &fakeRows{result: result}, nil
+}
+
+type fakeRows struct {
+	result fakeResult
+	next   int
+}
+
+func (r *fakeRows) Columns() []string { return r.result.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.next])
+	r.next++
+	return nil
+}
+
+func init() {
+	sql.Register("sqlerfake", fakeDriver{})
+}
+
+type Base struct {
+	ID        int64 `db:"id"`
+	CreatedAt time.Time
+}
+
+type Profile struct {
+	Nick string `db:"nick"`
+}
+
+type User struct {
+	Base
+	*Profile
+	Name     sql.NullString    `db:"name"`
+	Email    *string           `db:"email"`
+	DeleteAt *time.Time        `db:"delete_at"`
+	Extra    map[string]string `db:"extra,json"`
+	Ignored  string            `db:"-"`
+}
+
+func TestGetSelect(t *testing.T) {
+	db, err := sql.Open("sqlerfake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := (*DB)(db)
+	ctx := chain.WithContext(context.Background(), "trace-sqler")
+
+	fakeResults["users"] = fakeResult{
+		columns: []string{"id", "name", "email", "nick", "created_at", "delete_at", "extra"},
+		rows: [][]driver.Value{
+			{int64(1), "sbp", "a@b.c", "nick", []byte("2025-01-02 03:04:05"), nil, []byte(`{"k":"v"}`)},
+			{int64(2), nil, nil, "", time.Date(2025, 1, 3, 0, 0, 0, 0, time.Local), "2025-02-01", nil},
+		},
+	}
+	users, err := Select[User](ctx, s, "users")
+	if err != nil || len(users) != 2 {
+		t.Fatalf("users %+v err %v", users, err)
+	}
+	first, second := users[0], users[1]
+	if first.ID != 1 || first.Name.String != "sbp" || *first.Email != "a@b.c" || first.Nick != "nick" ||
+		first.CreatedAt.Format(time.DateTime) != "2025-01-02 03:04:05" || first.DeleteAt != nil || first.Extra["k"] != "v" {
+		t.Fatalf("first = %+v", first)
+	}
+	if second.Name.Valid || second.Email != nil || second.DeleteAt.Format(time.DateOnly) != "2025-02-01" || second.Extra != nil {
+		t.Fatalf("second = %+v", second)
+	}
+
+	user, err := Get[User](ctx, s, "users")
+	if err != nil || user.ID != 1 {
+		t.Fatalf("get %+v err %v", user, err)
+	}
+
+	fakeResults["count"] = fakeResult{columns: []string{"COUNT(*)"}, rows: [][]driver.Value{{int64(7)}}}
+	if count, err := Get[int](ctx, s, "count"); err != nil || count != 7 {
+		t.Fatalf("count %d err %v", count, err)
+	}
+
+	fakeResults["empty"] = fakeResult{columns: []string{"id"}}
+	if _, err = Get[User](ctx, s, "empty"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("empty err = %v", err)
+	}
+	if empty, err := Select[User](ctx, s, "empty"); err != nil || len(empty) != 0 {
+		t.Fatalf("empty %v err %v", empty, err)
+	}
+
+	fakeResults["unknown"] = fakeResult{columns: []string{"id", "missing"}}
+	if _, err = Select[User](ctx, s, "unknown"); err == nil {
+		t.Fatal("unknown column accepted")
+	}
+}
+
+type profile struct {
+	Nick string `db:"nick"`
+}
+
+type hiddenProfile struct {
+	ID int64 `db:"id"`
+	*profile
+}
+
+type Audit struct {
+	Nick string `db:"nick"`
+}
+
+type ambiguousUser struct {
+	ID int64 `db:"id"`
+	Profile
+	Audit
+}
+
+func TestSelectInvalidStruct(t *testing.T) {
+	db, err := sql.Open("sqlerfake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := (*DB)(db)
+	ctx := chain.WithContext(context.Background(), "trace-sqler")
+
+	fakeResults["nick"] = fakeResult{columns: []string{"id", "nick"}, rows: [][]driver.Value{{int64(1), "nick"}}}
+	if _, err = Select[hiddenProfile](ctx, s, "nick"); err == nil {
+		t.Fatal("embedded pointer to unexported struct accepted")
+	}
+	if _, err = Select[ambiguousUser](ctx, s, "nick"); err == nil {
+		t.Fatal("ambiguous column accepted")
+	}
+
+	// 不使用歧义列时正常映射
+	fakeResults["id"] = fakeResult{columns: []string{"id"}, rows: [][]driver.Value{{int64(1)}}}
+	if user, err := Get[ambiguousUser](ctx, s, "id"); err != nil || user.ID != 1 {
+		t.Fatalf("user %+v err %v", user, err)
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for name, want := range map[string]string{"ID": "id", "UserID": "user_id", "CreatedAt": "created_at", "HTTPServer": "http_server"} {
+		if got := snakeCase(name); got != want {
+			t.Errorf("snakeCase(%s) = %s, want %s", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package httpip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BreakerState 熔断器状态
+type BreakerState int
+
+const (
+	StateClosed   BreakerState = iota // 正常放行, 统计失败率
+	StateOpen                         // 熔断中, 直接失败
+	StateHalfOpen                     // 冷却结束, 放行少量探测请求
+)
+
+func (state BreakerState) String() string {
+	switch state {
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// ErrCircuitOpen 熔断中快速失败, errors.Is(err, httpip.ErrCircuitOpen) 判断
+var ErrCircuitOpen = errors.New("httpip: circuit breaker is open")
+
+// CircuitOpenError 熔断快速失败的具体信息
+type CircuitOpenError struct {
+	Host  string
+	Until time.Time // 预计进入 half-open 的时间
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("httpip: circuit breaker is open for %s until %s", e.Host, e.Until.Format(time.RFC3339))
+}
+
+// Is 支持 errors.Is(err, ErrCircuitOpen)
+func (e *CircuitOpenError) Is(target error) bool { return target == ErrCircuitOpen }
+
+// BreakerOptions 熔断配置, 零值字段使用默认值
+type BreakerOptions struct {
+	Window      time.Duration // 统计窗口, 默认 10s
+	MinRequests int           // 窗口内请求数达到后才判定, 默认 20
+	FailureRate float64       // 失败率 (连接错误和 5xx) 达到后熔断, 默认 0.5
+
+	SlowCall     time.Duration // 超过该耗时视为慢调用, 默认 5s
+	SlowCallRate float64       // 慢调用比例达到后熔断, 默认 0.8
+
+	CoolDown         time.Duration // 熔断持续时间, 之后进入 half-open, 默认 10s
+	HalfOpenRequests int           // half-open 放行的探测请求数, 全部成功才恢复, 默认 3
+}
+
+// BreakerTransport 按 host 熔断的 http.RoundTripper, 默认不启用, 通过 BreakerMiddleware 按需接入
+// 需要查询 State 时直接构造 &BreakerTransport{Next: ...}
+type BreakerTransport struct {
+	Next    http.RoundTripper
+	Options BreakerOptions
+
+	once      sync.Once
+	mu        sync.Mutex
+	hosts     map[string]*hostBreaker
+	lastSweep time.Time
+}
+
+// BreakerMiddleware 按 host 熔断的客户端中间件
+//
+//	httpip.UseTransport(httpip.BreakerMiddleware(httpip.BreakerOptions{}))  // 全局 HTTPClient 启用
+//	client := httpip.NewClient(httpip.BreakerMiddleware(options))           // 只作用于独立 client
+func BreakerMiddleware(options BreakerOptions) TransportMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return &BreakerTransport{Next: next, Options: options}
+	}
+}
+
+type hostBreaker struct {
+	state       BreakerState
+	lastUsed    time.Time // 最近一次放行, 用于回收空闲 host
+	windowStart time.Time
+	total       int
+	failures    int
+	slow        int
+
+	openUntil time.Time
+	probes    int // half-open 已放行的探测数
+	successes int // half-open 探测成功数
+}
+
+func (t *BreakerTransport) init() {
+	t.once.Do(func() {
+		options := &t.Options
+		if options.Window <= 0 {
+			options.Window = 10 * time.Second
+		}
+		if options.MinRequests <= 0 {
+			options.MinRequests = 20
+		}
+		if options.FailureRate <= 0 {
+			options.FailureRate = 0.5
+		}
+		if options.SlowCall <= 0 {
+			options.SlowCall = 5 * time.Second
+		}
+		if options.SlowCallRate <= 0 {
+			options.SlowCallRate = 0.8
+		}
+		if options.CoolDown <= 0 {
+			options.CoolDown = 10 * time.Second
+		}
+		if options.HalfOpenRequests <= 0 {
+			options.HalfOpenRequests = 3
+		}
+		t.hosts = make(map[string]*hostBreaker)
+	})
+}
+
+// State 查询 host 当前状态, 未出现过的 host 为 StateClosed
+func (t *BreakerTransport) State(host string) BreakerState {
+	t.init()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if hb := t.hosts[host]; hb != nil {
+		if hb.state == StateOpen && !time.Now().Before(hb.openUntil) {
+			return StateHalfOpen
+		}
+		return hb.state
+	}
+	return StateClosed
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *BreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.init()
+	ctx, host := req.Context(), req.URL.Host
+
+	if err := t.allow(ctx, host); err != nil {
+		return nil, err
+	}
+
+	begin := time.Now()
+	resp, err := t.Next.RoundTrip(req)
+	elapsed := time.Since(begin)
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// 调用方自己取消或超时, 不算下游失败, 只归还 half-open 名额
+		t.release(host)
+	case err != nil || resp.StatusCode >= http.StatusInternalServerError:
+		t.record(ctx, host, false, elapsed)
+	default:
+		t.record(ctx, host, true, elapsed)
+	}
+	return resp, err
+}
+
+func (t *BreakerTransport) allow(ctx context.Context, host string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.sweep(now)
+
+	hb := t.hosts[host]
+	if hb == nil {
+		hb = &hostBreaker{windowStart: now}
+		t.hosts[host] = hb
+	}
+	hb.lastUsed = now
+
+	if hb.state == StateOpen {
+		if time.Now().Before(hb.openUntil) {
+			return &CircuitOpenError{Host: host, Until: hb.openUntil}
+		}
+		t.transition(ctx, host, hb, StateHalfOpen)
+	}
+	if hb.state == StateHalfOpen {
+		if hb.probes >= t.Options.HalfOpenRequests {
+			return &CircuitOpenError{Host: host, Until: time.Now()}
+		}
+		hb.probes++
+	}
+	return nil
+}
+
+func (t *BreakerTransport) release(host string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if hb := t.hosts[host]; hb != nil && hb.state == StateHalfOpen && hb.probes > 0 {
+		hb.probes--
+	}
+}
+
+func (t *BreakerTransport) record(ctx context.Context, host string, success bool, elapsed time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hb := t.hosts[host]
+	if hb == nil {
+		// 请求过程中已经被回收
+		return
+	}
+	slow := elapsed >= t.Options.SlowCall
+
+	switch hb.state {
+	case StateHalfOpen:
+		if !success || slow {
+			t.transition(ctx, host, hb, StateOpen)
+			return
+		}
+		if hb.successes++; hb.successes >= t.Options.HalfOpenRequests {
+			t.transition(ctx, host, hb, StateClosed)
+		}
+	case StateClosed:
+		now := time.Now()
+		if now.Sub(hb.windowStart) > t.Options.Window {
+			hb.windowStart, hb.total, hb.failures, hb.slow = now, 0, 0, 0
+		}
+		hb.total++
+		if !success {
+			hb.failures++
+		}
+		if slow {
+			hb.slow++
+		}
+		if hb.total < t.Options.MinRequests {
+			return
+		}
+		if float64(hb.failures)/float64(hb.total) >= t.Options.FailureRate ||
+			float64(hb.slow)/float64(hb.total) >= t.Options.SlowCallRate {
+			t.transition(ctx, host, hb, StateOpen)
+		}
+	}
+	// StateOpen: 熔断前已经放出去的请求, 结果忽略
+}
+
+// sweep 每个统计窗口最多一次, 回收超过两个窗口没有请求的 closed host, 避免 hosts 无限增长; 调用方持有锁
+// open / half-open 的 host 保留熔断状态, 冷却结束恢复 closed 后再回收
+func (t *BreakerTransport) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < t.Options.Window {
+		return
+	}
+	t.lastSweep = now
+
+	idle := 2 * t.Options.Window
+	for host, hb := range t.hosts {
+		if hb.state == StateClosed && now.Sub(hb.lastUsed) > idle {
+			delete(t.hosts, host)
+		}
+	}
+}
+
+// transition 调用方持有锁
+func (t *BreakerTransport) transition(ctx context.Context, host string, hb *hostBreaker, to BreakerState) {
+	from := hb.state
+	hb.state = to
+	hb.probes, hb.successes = 0, 0
+
+	switch to {
+	case StateOpen:
+		hb.openUntil = time.Now().Add(t.Options.CoolDown)
+		slog.WarnContext(ctx, "httpip circuit breaker state change", "host", host, "from", from.String(), "to", to.String(),
+			"total", hb.total, "failures", hb.failures, "slow", hb.slow, "coolDown", t.Options.CoolDown)
+	case StateClosed:
+		hb.windowStart, hb.total, hb.failures, hb.slow = time.Now(), 0, 0, 0
+		slog.InfoContext(ctx, "httpip circuit breaker state change", "host", host, "from", from.String(), "to", to.String())
+	default:
+		slog.InfoContext(ctx, "httpip circuit breaker state change", "host", host, "from", from.String(), "to", to.String())
+	}
+}
@@ -0,0 +1,281 @@
+package httpip
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/wangzhione/sbp/util/jsou"
+	"github.com/wangzhione/sbp/util/tuml"
+)
+
+// ErrCassetteUnmatched 回放模式下请求在 cassette 中找不到对应记录
+var ErrCassetteUnmatched = errors.New("httpip: cassette unmatched request")
+
+// CassetteMode 录制 / 回放模式
+type CassetteMode int
+
+const (
+	// ModeReplay 只回放, 不发真实请求, 未匹配的请求直接返回 ErrCassetteUnmatched
+	ModeReplay CassetteMode = iota
+	// ModeRecord 发真实请求并记录, Save 时覆盖 cassette 文件
+	ModeRecord
+)
+
+// DefaultRedactHeaders 录制时默认脱敏的 header
+var DefaultRedactHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+
+// Cassette 录制文件内容, Path 后缀为 .toml 时用 TOML, 否则用 JSON
+type Cassette struct {
+	Interactions []Interaction `json:"interactions" toml:"interactions"`
+}
+
+// Interaction 一次请求和对应的响应
+type Interaction struct {
+	Request  CassetteRequest  `json:"request" toml:"request"`
+	Response CassetteResponse `json:"response" toml:"response"`
+}
+
+// CassetteRequest 录制的请求
+type CassetteRequest struct {
+	Method  string      `json:"method" toml:"method"`
+	URL     string      `json:"url" toml:"url"`
+	Headers http.Header `json:"headers,omitempty" toml:"headers,omitempty"`
+	Body    string      `json:"body,omitempty" toml:"body,omitempty"`
+	Base64  bool        `json:"base64,omitempty" toml:"base64,omitempty"` // Body 不是合法 UTF-8 时按 base64 保存
+}
+
+// CassetteResponse 录制的响应
+type CassetteResponse struct {
+	Status  int         `json:"status" toml:"status"`
+	Headers http.Header `json:"headers,omitempty" toml:"headers,omitempty"`
+	Body    string      `json:"body,omitempty" toml:"body,omitempty"`
+	Base64  bool        `json:"base64,omitempty" toml:"base64,omitempty"`
+}
+
+// RecorderOptions 录制回放配置
+type RecorderOptions struct {
+	Path string // cassette 文件路径, .toml 后缀用 TOML, 否则 JSON
+	Mode CassetteMode
+
+	// RedactHeaders 录制时替换为 REDACTED 的请求 / 响应 header, 默认 DefaultRedactHeaders
+	RedactHeaders []string
+
+	// Match 自定义匹配规则, 默认 method + URL + body 完全一致
+	Match func(req *http.Request, body []byte, recorded CassetteRequest) bool
+}
+
+// Recorder 录制回放 transport, 用于替代测试中真实的第三方接口
+//
+//	recorder, err := httpip.NewRecorder(httpip.RecorderOptions{Path: "testdata/partner.json"})
+//	httpip.HTTPClient = recorder.Client()
+//	defer recorder.Save()
+type Recorder struct {
+	options RecorderOptions
+
+	mu       sync.Mutex
+	cassette Cassette
+	used     []bool
+}
+
+// NewRecorder 回放模式会读取 cassette 文件, 文件不存在或格式错误直接返回错误
+func NewRecorder(options RecorderOptions) (*Recorder, error) {
+	if options.RedactHeaders == nil {
+		options.RedactHeaders = DefaultRedactHeaders
+	}
+	if options.Match == nil {
+		options.Match = matchCassette
+	}
+
+	recorder := &Recorder{options: options}
+	if options.Mode == ModeReplay {
+		var err error
+		if isTOML(options.Path) {
+			recorder.cassette, err = tuml.ReadFile[Cassette](options.Path)
+		} else {
+			recorder.cassette, err = jsou.ReadFile[Cassette](options.Path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("httpip: read cassette %s: %w", options.Path, err)
+		}
+		recorder.used = make([]bool, len(recorder.cassette.Interactions))
+	}
+	return recorder, nil
+}
+
+// Client 返回使用 Recorder 的 http.Client, 录制模式下真实请求走 HTTPClient 当前的 Transport
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r.Transport()(HTTPClient.Transport)}
+}
+
+// Transport 以 TransportMiddleware 形式接入, 可和 ChainTransport / NewClient 组合
+func (r *Recorder) Transport() TransportMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if r.options.Mode == ModeReplay {
+				return r.replay(req)
+			}
+			return r.record(req, next)
+		})
+	}
+}
+
+// Unused 回放模式下没有被请求过的记录, 测试结束时可据此断言所有录制的调用都发生了
+func (r *Recorder) Unused() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unused []Interaction
+	for i, used := range r.used {
+		if !used {
+			unused = append(unused, r.cassette.Interactions[i])
+		}
+	}
+	return unused
+}
+
+// Save 录制模式下写入 cassette 文件, 回放模式什么都不做
+func (r *Recorder) Save() error {
+	if r.options.Mode != ModeRecord {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(r.options.Path), 0o755); err != nil {
+		return err
+	}
+	if isTOML(r.options.Path) {
+		return tuml.WriteFile(r.options.Path, r.cassette)
+	}
+	return jsou.WriteFile(r.options.Path, r.cassette)
+}
+
+func (r *Recorder) replay(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// 相同请求按录制顺序依次返回
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] || !r.options.Match(req, body, interaction.Request) {
+			continue
+		}
+		r.used[i] = true
+
+		data, err := decodeCassetteBody(interaction.Response.Body, interaction.Response.Base64)
+		if err != nil {
+			return nil, err
+		}
+		status := interaction.Response.Status
+		return &http.Response{
+			Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+			StatusCode:    status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        interaction.Response.Headers.Clone(),
+			Body:          io.NopCloser(bytes.NewReader(data)),
+			ContentLength: int64(len(data)),
+			Request:       req,
+		}, nil
+	}
+
+	slog.ErrorContext(req.Context(), "httpip cassette unmatched request", "method", req.Method, "url", req.URL.String(), "body", truncated(body, 1024), "path", r.options.Path)
+	return nil, fmt.Errorf("%w: %s %s in %s", ErrCassetteUnmatched, req.Method, req.URL, r.options.Path)
+}
+
+func (r *Recorder) record(req *http.Request, next http.RoundTripper) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req = CloneRequest(req.Context(), req, body)
+	}
+
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+
+	interaction := Interaction{
+		Request:  CassetteRequest{Method: req.Method, URL: req.URL.String(), Headers: r.redact(req.Header)},
+		Response: CassetteResponse{Status: resp.StatusCode, Headers: r.redact(resp.Header)},
+	}
+	interaction.Request.Body, interaction.Request.Base64 = encodeCassetteBody(body)
+	interaction.Response.Body, interaction.Response.Base64 = encodeCassetteBody(data)
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	r.mu.Unlock()
+	return resp, nil
+}
+
+func (r *Recorder) redact(header http.Header) http.Header {
+	header = header.Clone()
+	for _, key := range r.options.RedactHeaders {
+		if _, ok := header[http.CanonicalHeaderKey(key)]; ok {
+			header.Set(key, "REDACTED")
+		}
+	}
+	return header
+}
+
+// matchCassette 默认匹配规则 method + URL + body
+func matchCassette(req *http.Request, body []byte, recorded CassetteRequest) bool {
+	if req.Method != recorded.Method || req.URL.String() != recorded.URL {
+		return false
+	}
+	data, err := decodeCassetteBody(recorded.Body, recorded.Base64)
+	return err == nil && bytes.Equal(body, data)
+}
+
+// readRequestBody 读取并关闭请求体, 没有请求体返回 nil
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	return body, err
+}
+
+func encodeCassetteBody(data []byte) (string, bool) {
+	if utf8.Valid(data) {
+		return string(data), false
+	}
+	return base64.StdEncoding.EncodeToString(data), true
+}
+
+func decodeCassetteBody(body string, isBase64 bool) ([]byte, error) {
+	if isBase64 {
+		return base64.StdEncoding.DecodeString(body)
+	}
+	return []byte(body), nil
+}
+
+func isTOML(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".toml")
+}
@@ -1,13 +1,16 @@
 package httpip
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
 	"fmt"
 	"io"
 	"net/http"
-
-	"github.com/wangzhione/sbp/chain"
+	"strings"
 )
 
 //
@@ -25,8 +28,88 @@ var HTTPTransport = func() *http.Transport {
 	return transport
 }()
 
+// HTTPClient 默认 client: 透明解压 -> HTTPTransport; 熔断等按需通过 UseTransport 接入
 var HTTPClient = &http.Client{
-	Transport: HTTPTransport,
+	Transport: &DecompressTransport{Next: HTTPTransport},
+}
+
+// DecompressTransport 主动声明 Accept-Encoding: gzip, deflate 并透明解压响应
+// http.Transport 默认只自动处理 gzip, 且调用方一旦自己设置 Accept-Encoding 就不再解压; 这里统一兜底
+// 调用方设置了 Accept-Encoding 时视为自己处理, 原样返回
+type DecompressTransport struct {
+	Next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *DecompressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	if req.Header.Get("Accept-Encoding") != "" || req.Header.Get("Range") != "" {
+		return next.RoundTrip(req)
+	}
+
+	// RoundTripper 不能修改入参 req
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+
+	resp, err := next.RoundTrip(req)
+	if err != nil || req.Method == http.MethodHead {
+		return resp, err
+	}
+
+	var body io.ReadCloser
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip", "x-gzip":
+		body = &lazyReadCloser{body: resp.Body, open: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }}
+	case "deflate":
+		body = &lazyReadCloser{body: resp.Body, open: openDeflate}
+	default:
+		return resp, nil
+	}
+
+	resp.Body = body
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// openDeflate HTTP deflate 标准是 zlib 格式, 但有些服务直接返回 raw deflate, 两种都兼容
+func openDeflate(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(2)
+	if err == nil && head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// lazyReadCloser 第一次 Read 时才创建解压 reader, 空 body 和 204 不会因为读 header 报错
+type lazyReadCloser struct {
+	body   io.ReadCloser
+	open   func(io.Reader) (io.Reader, error)
+	reader io.Reader
+	err    error
+}
+
+func (l *lazyReadCloser) Read(p []byte) (int, error) {
+	if l.reader == nil && l.err == nil {
+		l.reader, l.err = l.open(l.body)
+	}
+	if l.err != nil {
+		return 0, l.err
+	}
+	return l.reader.Read(p)
+}
+
+func (l *lazyReadCloser) Close() error {
+	if closer, ok := l.reader.(io.Closer); ok {
+		_ = closer.Close()
+	}
+	return l.body.Close()
 }
 
 // HTTPResponseCodeError http code error 构建
@@ -58,7 +141,7 @@ func CloneRequest(ctx context.Context, req *http.Request, body []byte) (newreq *
 }
 
 func Data(ctx context.Context, req *http.Request) (data []byte, err error) {
-	resp, err := HTTPClient.Do(req)
+	resp, err := send(ctx, req)
 	if err != nil {
 		// 超时错误 case : errors.Is(err, context.DeadlineExceeded)
 		return
@@ -75,37 +158,8 @@ func Data(ctx context.Context, req *http.Request) (data []byte, err error) {
 	return
 }
 
-// Call 基础 http call 操作 low api
+// Call 基础 http call 操作 low api, 更多配置使用 RequestData
+// 状态码错误时同时返回响应体和错误
 func Call(ctx context.Context, method, url string, headers map[string]string, reqData []byte) (respData []byte, err error) {
-	var body io.Reader
-	if len(reqData) > 0 {
-		body = bytes.NewBuffer(reqData)
-	}
-	req, err := http.NewRequestWithContext(ctx, method, url, body)
-	if err != nil {
-		return
-	}
-
-	// 设置默认 X-Request-Id
-	req.Header.Set(chain.XRquestID, chain.GetTraceID(ctx))
-	for key, value := range headers {
-		req.Header.Set(key, value)
-	}
-
-	resp, err := HTTPClient.Do(req)
-	if err != nil {
-		// 被动取消 case : errors.Is(err, context.Canceled)
-		// 超时错误 case : errors.Is(err, context.DeadlineExceeded)
-		return
-	}
-	defer resp.Body.Close()
-
-	respData, err = io.ReadAll(resp.Body)
-	if err != nil {
-		return
-	}
-
-	// 错误状态码返回错误信息
-	err = HTTPResponseCodeError(resp)
-	return
+	return RequestData(ctx, method, url, WithBody(reqData, ""), WithHeaders(headers))
 }
@@ -2,21 +2,121 @@ package httpip
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/wangzhione/sbp/chain"
 	"github.com/wangzhione/sbp/util/filedir"
+	"github.com/wangzhione/sbp/util/idhash"
 )
 
-// Download 下载 uri 到本地文件 outputPath
+var (
+	// ErrDownloadTooLarge 超过 DownloadOptions.MaxSize
+	ErrDownloadTooLarge = errors.New("httpip: download exceeds max size")
+	// ErrChecksumMismatch 下载完成后校验和不一致, 临时文件会被删除
+	ErrChecksumMismatch = errors.New("httpip: download checksum mismatch")
+)
+
+// DownloadOptions 下载配置
+type DownloadOptions struct {
+	Headers map[string]string
+
+	// Progress 进度回调, written 已写入字节数 (包含断点续传之前的部分), total 未知时为 -1
+	Progress func(written, total int64)
+	// MaxSize > 0 时限制文件大小, 超过直接失败
+	MaxSize int64
+
+	// MD5 / SHA256 期望的小写 16 进制校验和, 在原子 rename 之前校验
+	MD5    string
+	SHA256 string
+
+	// Resume 为 true 时失败保留 {outputpath}.part, 下次调用通过 HTTP Range + If-Range 断点续传
+	// 默认失败清理临时文件, 和 Download 老行为一致
+	Resume bool
+
+	// WithContext 为 true 时请求受 ctx 取消和超时控制; 默认和 Download 一致, 不受 ctx 生命周期影响
+	WithContext bool
+	Client      *http.Client // 默认 http.DefaultClient
+}
+
+// Download 下载 uri 到本地文件 outputPath, 失败时清理临时文件; 需要断点续传使用 DownloadWithOptions + Resume
 func Download(ctx context.Context, uri, outputpath string, headerargs ...map[string]string) error {
-	// 希望这个 http request 不被 传入的 context 影响生命周期被取消中断
-	// 如何你想主动控制下载行为, 可以自定义函数去 http.NewRequestWithContext 处理
-	// 最早这个函数是 http.NewRequestWithContext 处理, 很灵活, 但用起来往往出错, 给傻瓜安全, 给机灵鬼自由
-	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	headers := make(map[string]string)
+	for _, args := range headerargs {
+		for key, value := range args {
+			headers[key] = value
+		}
+	}
+	return DownloadWithOptions(ctx, uri, outputpath, DownloadOptions{Headers: headers})
+}
+
+// DownloadWithOptions 支持断点续传, 进度回调, 大小限制和校验和的下载
+// 数据先写入临时文件, 校验通过后原子 rename 到 outputpath
+// 默认临时文件为 {outputpath}.*.temp, 同一路径并发下载互不影响; Resume 时固定为 {outputpath}.part, 调用方需要自己避免并发
+func DownloadWithOptions(ctx context.Context, uri, outputpath string, options DownloadOptions) (err error) {
+	dir := filepath.Dir(outputpath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		slog.ErrorContext(ctx, "os.MkdirAll error", "error", err, "outputpath", outputpath)
+		return err
+	}
+
+	part := outputpath + ".part"
+	if !options.Resume {
+		temp, err := os.CreateTemp(dir, filepath.Base(outputpath)+".*.temp")
+		if err != nil {
+			slog.ErrorContext(ctx, "os.CreateTemp error", "error", err, "outputpath", outputpath)
+			return err
+		}
+		temp.Close()
+		part = temp.Name()
+	}
+	defer func() {
+		if err != nil && !options.Resume {
+			os.Remove(part)
+		}
+	}()
+
+	err = downloadPart(ctx, uri, part, options, options.Resume)
+	if err != nil {
+		slog.ErrorContext(ctx, "Download error", "error", err, "uri", uri, "outputpath", outputpath)
+		return err
+	}
+
+	if err = verifyChecksum(part, options); err != nil {
+		// 内容已经损坏, 不能再续传
+		os.Remove(part)
+		os.Remove(part + ".validator")
+		slog.ErrorContext(ctx, "Download checksum error", "error", err, "uri", uri, "outputpath", outputpath)
+		return err
+	}
+
+	if err = renameSync(part, outputpath); err != nil {
+		slog.ErrorContext(ctx, "Download rename error", "error", err, "outputpath", outputpath)
+		return err
+	}
+	os.Remove(part + ".validator")
+	return nil
+}
+
+// downloadPart 把 uri 下载 (续传) 到 part 文件
+func downloadPart(ctx context.Context, uri, part string, options DownloadOptions, resume bool) error {
+	reqctx := context.Background()
+	if options.WithContext {
+		reqctx = ctx
+	}
+	// 希望这个 http request 默认不被 传入的 context 影响生命周期被取消中断
+	// 如何你想主动控制下载行为, 设置 DownloadOptions.WithContext
+	req, err := http.NewRequestWithContext(reqctx, http.MethodGet, uri, nil)
 	if err != nil {
-		slog.ErrorContext(ctx, "http.NewRequest error", "error", err, "uri", uri)
 		return err
 	}
 
@@ -24,34 +124,187 @@ func Download(ctx context.Context, uri, outputpath string, headerargs ...map[str
 	req.Header.Set("Accept", "*/*")
 	req.Header.Set("Connection", "keep-alive")
 	req.Header.Set(chain.XRquestID, chain.GetTraceID(ctx))
-	for _, headers := range headerargs {
-		for key, value := range headers {
-			req.Header.Set(key, value)
+	for key, value := range options.Headers {
+		req.Header.Set(key, value)
+	}
+
+	// 只有保存了 ETag / Last-Modified 才续传, 远端文件变化时 If-Range 不成立, 服务端返回 200 全量内容
+	// 没有校验值无法判断远端是否变化, 续传可能拼出损坏的文件, 从头下载
+	var offset int64
+	if resume {
+		validator, err := os.ReadFile(part + ".validator")
+		if info, statErr := os.Stat(part); statErr == nil && err == nil && len(validator) > 0 {
+			offset = info.Size()
+			req.Header.Set("If-Range", string(validator))
 		}
 	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	client := options.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		// 超时错误 case : errors.Is(err, context.DeadlineExceeded)
-		slog.ErrorContext(ctx, "HTTPClient.Do error", "error", err, "uri", uri)
 		return err
 	}
 	defer resp.Body.Close()
 
-	// 错误状态码返回错误信息
-	if err = HTTPResponseCodeError(resp); err != nil {
-		slog.ErrorContext(ctx, "HTTPResponseCodeError error", "error", err, "uri", uri)
-		return err
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if start, _, _ := parseContentRange(resp.Header.Get("Content-Range")); start != offset {
+			return fmt.Errorf("httpip: unexpected Content-Range %q for offset %d", resp.Header.Get("Content-Range"), offset)
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// part 已经完整, 或者远端文件变小了, 后者从头开始
+		if _, _, size := parseContentRange(resp.Header.Get("Content-Range")); offset > 0 && size == offset {
+			return nil
+		}
+		if offset > 0 && resume {
+			io.Copy(io.Discard, resp.Body)
+			return downloadPart(ctx, uri, part, options, false)
+		}
+		return HTTPResponseCodeError(resp)
+	default:
+		if err = HTTPResponseCodeError(resp); err != nil {
+			return err
+		}
+		// 服务端不支持 Range 或文件已变化, 全量重新下载
+		offset = 0
+	}
+
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+	if options.MaxSize > 0 && total > options.MaxSize {
+		return fmt.Errorf("%w: %d > %d", ErrDownloadTooLarge, total, options.MaxSize)
 	}
 
-	// 下载内容直接流式写入临时文件，避免大文件占满内存
-	err = filedir.FSyncWriteReader(outputpath, resp.Body, 0o664)
+	flag := os.O_WRONLY | os.O_CREATE
+	if offset == 0 && options.Resume {
+		if validator := resp.Header.Get("ETag"); validator != "" && !strings.HasPrefix(validator, "W/") {
+			_ = os.WriteFile(part+".validator", []byte(validator), 0o664)
+		} else if validator = resp.Header.Get("Last-Modified"); validator != "" {
+			_ = os.WriteFile(part+".validator", []byte(validator), 0o664)
+		} else {
+			os.Remove(part + ".validator")
+		}
+	}
+	if offset == 0 {
+		flag |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(part, flag, 0o664)
 	if err != nil {
-		slog.ErrorContext(ctx, "FSyncWriteReader error", "error", err, "outputpath", outputpath, "uri", uri)
+		return err
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+
+	var body io.Reader = resp.Body
+	if options.MaxSize > 0 {
+		// 多读 1 字节用于判断是否超限
+		body = io.LimitReader(resp.Body, options.MaxSize-offset+1)
+	}
+	written, err := io.Copy(&progressWriter{w: file, written: offset, total: total, progress: options.Progress}, body)
+	if err == nil && options.MaxSize > 0 && offset+written > options.MaxSize {
+		err = fmt.Errorf("%w: > %d", ErrDownloadTooLarge, options.MaxSize)
+		_ = file.Truncate(0)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
 	}
 	return err
 }
 
+// verifyChecksum 按 options.MD5 / options.SHA256 校验文件, 都为空直接通过
+func verifyChecksum(path string, options DownloadOptions) error {
+	if options.MD5 != "" {
+		sign, err := idhash.MD5File(path)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(sign, options.MD5) {
+			return fmt.Errorf("%w: md5 %s, expected %s", ErrChecksumMismatch, sign, options.MD5)
+		}
+	}
+	if options.SHA256 != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		d := sha256.New()
+		if _, err = io.Copy(d, file); err != nil {
+			return err
+		}
+		if sign := hex.EncodeToString(d.Sum(nil)); !strings.EqualFold(sign, options.SHA256) {
+			return fmt.Errorf("%w: sha256 %s, expected %s", ErrChecksumMismatch, sign, options.SHA256)
+		}
+	}
+	return nil
+}
+
+// renameSync 原子替换并 fsync 目录项 (尽力而为)
+func renameSync(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	if dirFile, err := os.Open(filepath.Dir(to)); err == nil {
+		_ = dirFile.Sync()
+		dirFile.Close()
+	}
+	return nil
+}
+
+// parseContentRange 解析 "bytes 100-199/1000" 和 "bytes */1000", 未知部分返回 -1
+func parseContentRange(value string) (start, end, size int64) {
+	start, end, size = -1, -1, -1
+	value, ok := strings.CutPrefix(value, "bytes ")
+	if !ok {
+		return
+	}
+	span, total, _ := strings.Cut(value, "/")
+	if n, err := strconv.ParseInt(total, 10, 64); err == nil {
+		size = n
+	}
+	if first, last, ok := strings.Cut(span, "-"); ok {
+		if n, err := strconv.ParseInt(first, 10, 64); err == nil {
+			start = n
+		}
+		if n, err := strconv.ParseInt(last, 10, 64); err == nil {
+			end = n
+		}
+	}
+	return
+}
+
+// progressWriter 写入时回调进度
+type progressWriter struct {
+	w        io.Writer
+	written  int64
+	total    int64
+	progress func(written, total int64)
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.written += int64(n)
+	if pw.progress != nil && n > 0 {
+		pw.progress(pw.written, pw.total)
+	}
+	return n, err
+}
+
 // DownloadIfNotExists 下载文件（如果文件已存在则跳过），失败时清理临时文件
 func DownloadIfNotExists(ctx context.Context, uri, outputpath string, headerargs ...map[string]string) (err error) {
 	// 如果目标文件已存在，直接跳过
@@ -0,0 +1,282 @@
+package httpip
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wangzhione/sbp/chain"
+)
+
+// ChunkedOptions 分片并发下载配置, 校验和 / 进度 / 大小限制等沿用 DownloadOptions
+type ChunkedOptions struct {
+	DownloadOptions
+
+	Concurrency int   // 最大并发分片数, 默认 4
+	ChunkSize   int64 // 单个分片大小, 默认 16MB
+	Retries     int   // 单个分片失败后的重试次数, 默认 3, 负数不重试
+}
+
+// DownloadChunked 分片并发下载大文件
+// 先用 Range: bytes=0-0 探测文件大小和是否支持 Range, 不支持时退化为 DownloadWithOptions
+// 支持时预分配临时文件, 限制并发按 Range 分片下载, 每个分片独立重试, 全部完成并校验后原子 rename
+func DownloadChunked(ctx context.Context, uri, outputpath string, options ChunkedOptions) (err error) {
+	if options.Concurrency <= 0 {
+		options.Concurrency = 4
+	}
+	if options.ChunkSize <= 0 {
+		options.ChunkSize = 16 << 20
+	}
+	if options.Retries < 0 {
+		options.Retries = 0
+	} else if options.Retries == 0 {
+		options.Retries = 3
+	}
+	if options.Client == nil {
+		options.Client = http.DefaultClient
+	}
+
+	reqctx := context.Background()
+	if options.WithContext {
+		reqctx = ctx
+	}
+	// 保留 trace id, 便于日志串联
+	reqctx = chain.WithContext(reqctx, chain.GetTraceID(ctx))
+
+	size, validator, ok, err := probeRange(reqctx, uri, options)
+	if err != nil {
+		slog.ErrorContext(ctx, "DownloadChunked probe error", "error", err, "uri", uri)
+		return err
+	}
+	if !ok || size <= options.ChunkSize {
+		return DownloadWithOptions(ctx, uri, outputpath, options.DownloadOptions)
+	}
+	if options.MaxSize > 0 && size > options.MaxSize {
+		return fmt.Errorf("%w: %d > %d", ErrDownloadTooLarge, size, options.MaxSize)
+	}
+
+	dir, base := filepath.Dir(outputpath), filepath.Base(outputpath)
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(dir, base+".*.temp")
+	if err != nil {
+		return err
+	}
+	name := temp.Name()
+	defer func() {
+		if err != nil {
+			temp.Close()
+			os.Remove(name)
+		}
+	}()
+	// 预分配, 各分片 WriteAt 到自己的区间
+	if err = temp.Truncate(size); err != nil {
+		return err
+	}
+
+	begin := time.Now()
+	var chunkErr error
+	progress := &chunkProgress{total: size, progress: options.Progress}
+
+	chunkctx, cancel := context.WithCancel(reqctx)
+	defer cancel()
+	// groupgo 依赖 httpip, 这里不能反向引用, 用 channel 信号量限制并发
+	var wg sync.WaitGroup
+	var once sync.Once
+	sem := make(chan struct{}, options.Concurrency)
+	for start := int64(0); start < size && chunkctx.Err() == nil; start += options.ChunkSize {
+		end := min(start+options.ChunkSize, size) - 1
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := downloadChunk(chunkctx, uri, temp, start, end, validator, options, progress); err != nil {
+				once.Do(func() { chunkErr = err })
+				// 一个分片彻底失败, 其他分片没有必要继续
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+	if err = chunkErr; err != nil {
+		slog.ErrorContext(ctx, "DownloadChunked error", "error", err, "uri", uri, "outputpath", outputpath)
+		return err
+	}
+
+	if err = temp.Sync(); err != nil {
+		return err
+	}
+	if err = temp.Close(); err != nil {
+		return err
+	}
+	if err = verifyChecksum(name, options.DownloadOptions); err != nil {
+		slog.ErrorContext(ctx, "DownloadChunked checksum error", "error", err, "uri", uri, "outputpath", outputpath)
+		return err
+	}
+	if err = os.Rename(name, outputpath); err != nil {
+		return err
+	}
+	// fsync 目录项（尽力而为）
+	if dirFile, err := os.Open(dir); err == nil {
+		_ = dirFile.Sync()
+		dirFile.Close()
+	}
+
+	slog.InfoContext(ctx, "DownloadChunked success", "uri", uri, "outputpath", outputpath, "size", size,
+		"chunks", (size+options.ChunkSize-1)/options.ChunkSize, "elapsed", time.Since(begin).String())
+	return nil
+}
+
+// probeRange 返回文件大小, If-Range 校验值, 是否支持 Range
+func probeRange(ctx context.Context, uri string, options ChunkedOptions) (size int64, validator string, ok bool, err error) {
+	req, err := newChunkRequest(ctx, uri, options, "bytes=0-0")
+	if err != nil {
+		return
+	}
+	resp, err := options.Client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	// 只有 206 / 416 的响应体很小, 读完复用连接; 服务端忽略 Range 返回 200 全量内容时直接关闭, 不能白白下载一遍
+	switch resp.StatusCode {
+	case http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+		io.Copy(io.Discard, resp.Body)
+	}
+
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// 空文件
+		return
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		err = HTTPResponseCodeError(resp)
+		return
+	}
+	_, total, found := strings.Cut(resp.Header.Get("Content-Range"), "/")
+	if !found {
+		return
+	}
+	if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+		// bytes 0-0/* 大小未知
+		return 0, "", false, nil
+	}
+
+	// 弱 ETag 不能用于 If-Range
+	if validator = resp.Header.Get("ETag"); validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+	return size, validator, true, nil
+}
+
+func newChunkRequest(ctx context.Context, uri string, options ChunkedOptions, byteRange string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "*/*")
+	req.Header.Set(chain.XRquestID, chain.GetTraceID(ctx))
+	for key, value := range options.Headers {
+		req.Header.Set(key, value)
+	}
+	req.Header.Set("Range", byteRange)
+	// 分片必须是原始字节, 不能被透明压缩
+	req.Header.Set("Accept-Encoding", "identity")
+	return req, nil
+}
+
+// downloadChunk 下载 [start, end] 区间, 失败后从已写入的位置继续重试
+func downloadChunk(ctx context.Context, uri string, file *os.File, start, end int64, validator string, options ChunkedOptions, progress *chunkProgress) (err error) {
+	offset := start
+	for attempt := 0; attempt <= options.Retries; attempt++ {
+		if attempt > 0 {
+			slog.WarnContext(ctx, "DownloadChunked chunk retry", "error", err, "start", start, "end", end, "offset", offset, "attempt", attempt)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * 200 * time.Millisecond):
+			}
+		}
+
+		var written int64
+		written, err = fetchChunk(ctx, uri, file, offset, end, validator, options, progress)
+		offset += written
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func fetchChunk(ctx context.Context, uri string, file *os.File, offset, end int64, validator string, options ChunkedOptions, progress *chunkProgress) (int64, error) {
+	req, err := newChunkRequest(ctx, uri, options, "bytes="+strconv.FormatInt(offset, 10)+"-"+strconv.FormatInt(end, 10))
+	if err != nil {
+		return 0, err
+	}
+	if validator != "" {
+		req.Header.Set("If-Range", validator)
+	}
+
+	resp, err := options.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		// If-Range 不成立时服务端返回 200 全量内容, 说明远端文件已经变化
+		return 0, fmt.Errorf("error: chunk %d-%d HTTP Code %d %s", offset, end, resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+	if contentRange := resp.Header.Get("Content-Range"); !strings.HasPrefix(contentRange, "bytes "+strconv.FormatInt(offset, 10)+"-") {
+		return 0, fmt.Errorf("error: chunk %d-%d unexpected Content-Range %q", offset, end, contentRange)
+	}
+
+	want := end - offset + 1
+	written, err := io.Copy(&chunkWriter{w: io.NewOffsetWriter(file, offset), progress: progress}, io.LimitReader(resp.Body, want))
+	if err == nil && written != want {
+		err = io.ErrUnexpectedEOF
+	}
+	return written, err
+}
+
+// chunkProgress 多个分片并发汇总进度
+type chunkProgress struct {
+	mu       sync.Mutex
+	written  int64
+	total    int64
+	progress func(written, total int64)
+}
+
+type chunkWriter struct {
+	w        io.Writer
+	progress *chunkProgress
+}
+
+func (cw *chunkWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	if n > 0 {
+		// 加锁保证回调串行且 written 单调递增
+		cw.progress.mu.Lock()
+		cw.progress.written += int64(n)
+		if cw.progress.progress != nil {
+			cw.progress.progress(cw.progress.written, cw.progress.total)
+		}
+		cw.progress.mu.Unlock()
+	}
+	return n, err
+}
@@ -0,0 +1,106 @@
+package httpip
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wangzhione/sbp/chain"
+	"github.com/wangzhione/sbp/util/idhash"
+)
+
+func TestDownloadChunked(t *testing.T) {
+	ctx := chain.WithContext(chain.BC, "trace-chunked")
+	content := []byte(strings.Repeat("abcdefghij", 10000))
+	modtime := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+
+	var mu sync.Mutex
+	requests := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		byteRange := r.Header.Get("Range")
+		requests[byteRange]++
+		first := requests[byteRange] == 1
+		mu.Unlock()
+
+		// 第二个分片第一次只返回一半, 触发分片独立重试
+		if strings.HasPrefix(byteRange, "bytes=30000-") && first {
+			w.Header().Set("Content-Range", "bytes 30000-59999/100000")
+			w.Header().Set("Content-Length", "30000")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(content[30000:45000])
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "model.bin", modtime, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	output := filepath.Join(t.TempDir(), "model.bin")
+	var last int64
+	err := DownloadChunked(ctx, server.URL, output, ChunkedOptions{
+		DownloadOptions: DownloadOptions{
+			MD5:      idhash.MD5(content),
+			Progress: func(written, total int64) { last = written },
+		},
+		Concurrency: 2,
+		ChunkSize:   30000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(output); !bytes.Equal(data, content) {
+		t.Fatal("content mismatch")
+	}
+	if last != int64(len(content)) {
+		t.Fatalf("progress %d", last)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests["bytes=0-0"] != 1 || requests["bytes=0-29999"] != 1 || requests["bytes=90000-99999"] != 1 {
+		t.Fatalf("requests = %v", requests)
+	}
+	// 重试从已写入的位置继续
+	if requests["bytes=45000-59999"] != 1 {
+		t.Fatalf("chunk retry should resume, requests = %v", requests)
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(output), "*.temp"))
+	if len(matches) != 0 {
+		t.Fatalf("temp files left: %v", matches)
+	}
+}
+
+func TestDownloadChunkedNoRange(t *testing.T) {
+	content := []byte(strings.Repeat("abcdefghij", 10000))
+	var mu sync.Mutex
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		// 忽略 Range 直接返回全量内容
+		_, _ = w.Write(content)
+	}))
+	defer server.Close()
+
+	output := filepath.Join(t.TempDir(), "model.bin")
+	if err := DownloadChunked(chain.Context(), server.URL, output, ChunkedOptions{ChunkSize: 30000}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(output); !bytes.Equal(data, content) {
+		t.Fatal("content mismatch")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 2 {
+		t.Fatalf("calls = %d, expected probe + full download", calls)
+	}
+}
@@ -2,7 +2,6 @@
 package httpip
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"io"
@@ -12,8 +11,10 @@ import (
 )
 
 // Do http.Request 发起 http 调用, 返回 body 用 application/json 协议
+// req context 存在 deadline 时自动携带 chain.XRequestTimeout, 下游据此提前放弃
 func Do(ctx context.Context, req *http.Request, response any) (err error) {
-	resp, err := HTTPClient.Do(req)
+	chain.SetDeadlineHeader(req.Context(), req.Header)
+	resp, err := send(ctx, req)
 	if err != nil {
 		// 超时错误 case : errors.Is(err, context.DeadlineExceeded)
 		return err
@@ -36,33 +37,11 @@ func Do(ctx context.Context, req *http.Request, response any) (err error) {
 	return
 }
 
-// DoRequest 统一处理 HTTP 请求
-// http timeout 逻辑, 依赖外围 context.WithTimeout(ctx, time.Duration)
+// DoRequest 统一处理 HTTP 请求, 更多配置 (超时 / query / 鉴权 / 期望状态码) 使用 Request
+// http timeout 逻辑, 依赖外围 context.WithTimeout(ctx, time.Duration) 或 WithTimeout
+// response 为 nil 时丢弃响应体, 状态码正常即返回 nil (以前会返回 json: Unmarshal(nil) 错误)
 func DoRequest(ctx context.Context, method, url string, headers map[string]string, request, response any) (err error) {
-	var body io.Reader
-	// 将请求体转换为 JSON
-	if request != nil {
-		var data []byte
-		data, err = json.Marshal(request)
-		if err != nil {
-			return err
-		}
-		body = bytes.NewBuffer(data)
-	}
-
-	req, err := http.NewRequestWithContext(ctx, method, url, body)
-	if err != nil {
-		return err
-	}
-
-	// 设置默认 Content-Type X-Request-Id
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set(chain.XRquestID, chain.GetTraceID(ctx))
-	for key, value := range headers {
-		req.Header.Set(key, value)
-	}
-
-	return Do(ctx, req, response)
+	return Request(ctx, method, url, response, WithJSON(request), WithHeaders(headers))
 }
 
 // Get 发送 GET 请求，并支持自定义超时时间
@@ -1,13 +1,31 @@
 package httpip
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/wangzhione/sbp/chain"
+	"github.com/wangzhione/sbp/https/websocket"
+	"github.com/wangzhione/sbp/util/filedir"
+	"github.com/wangzhione/sbp/util/idhash"
 )
 
 // 结构体定义（用于测试 JSON 响应）
@@ -113,3 +131,756 @@ func TestCall(t *testing.T) {
 
 	t.Log(string(respData))
 }
+
+func TestDialWebSocket(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(chain.XRquestID) != "trace-dial" {
+			t.Errorf("unexpected trace id: %q", r.Header.Get(chain.XRquestID))
+		}
+
+		conn, err := websocket.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("welcome"))
+		_, _, _ = conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	ctx := chain.WithContext(context.Background(), "trace-dial")
+	conn, err := DialWebSocket(ctx, "ws"+strings.TrimPrefix(server.URL, "http"), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, data, err := conn.ReadMessage()
+	if err != nil || string(data) != "welcome" {
+		t.Fatalf("ReadMessage %q %v", data, err)
+	}
+}
+
+func TestDecompressTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip, deflate" {
+			t.Errorf("unexpected Accept-Encoding: %q", r.Header.Get("Accept-Encoding"))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/deflate" {
+			w.Header().Set("Content-Encoding", "deflate")
+			zw := zlib.NewWriter(w)
+			defer zw.Close()
+			_ = json.NewEncoder(zw).Encode(TestResponse{Message: "deflate"})
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gw := gzip.NewWriter(w)
+		defer gw.Close()
+		_ = json.NewEncoder(gw).Encode(TestResponse{Message: "gzip"})
+	}))
+	defer server.Close()
+
+	for _, path := range []string{"/gzip", "/deflate"} {
+		var response TestResponse
+		if err := Get(context.Background(), server.URL+path, nil, &response); err != nil {
+			t.Fatal(path, err)
+		}
+		if "/"+response.Message != path {
+			t.Fatalf("%s: unexpected response %q", path, response.Message)
+		}
+	}
+}
+
+func TestDoDeadlineHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`"` + r.Header.Get(chain.XRequestTimeout) + `"`))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(chain.Context(), 3*time.Second)
+	defer cancel()
+
+	var budget string
+	if err := Get(ctx, server.URL, nil, &budget); err != nil {
+		t.Fatal(err)
+	}
+	if ms, err := strconv.Atoi(budget); err != nil || ms <= 2000 || ms > 3000 {
+		t.Fatalf("X-Request-Timeout = %q", budget)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var calls int
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		data, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(data))
+		switch calls {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			_, _ = w.Write([]byte(`{"message":"ok"}`))
+		}
+	}))
+	defer server.Close()
+
+	policy := &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}
+	ctx := WithRetry(chain.Context(), policy)
+
+	var resp TestResponse
+	if err := Put(ctx, server.URL, nil, map[string]string{"k": "v"}, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 || resp.Message != "ok" {
+		t.Fatalf("calls %d resp %+v", calls, resp)
+	}
+	for _, body := range bodies {
+		if body != `{"k":"v"}` {
+			t.Fatalf("body not replayed: %q", bodies)
+		}
+	}
+
+	// POST 默认不重试
+	calls = 0
+	if err := Post(ctx, server.URL, nil, nil, &resp); err == nil || calls != 1 {
+		t.Fatalf("post err %v calls %d", err, calls)
+	}
+
+	// 显式开启后重试
+	calls = 0
+	policy.NonIdempotent = true
+	if _, err := Call(ctx, http.MethodPost, server.URL, nil, []byte("x")); err != nil || calls != 3 {
+		t.Fatalf("opt-in post err %v calls %d", err, calls)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	// BaseDelay 很大时移位会溢出, 不能得到负数或 panic
+	policy := &RetryPolicy{BaseDelay: time.Duration(1) << 60, MaxDelay: time.Duration(1) << 62}
+	for retry := range 40 {
+		if delay := policy.backoff(retry); delay <= 0 || delay > policy.MaxDelay+1 {
+			t.Fatalf("backoff(%d) = %v", retry, delay)
+		}
+	}
+
+	policy = &RetryPolicy{BaseDelay: 10 * time.Millisecond, MaxDelay: 100 * time.Millisecond}
+	if delay := policy.backoff(2); delay > 40*time.Millisecond+1 {
+		t.Fatalf("backoff(2) = %v", delay)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "10")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx := WithRetry(chain.Context(), &RetryPolicy{MaxAttempts: 5, Budget: time.Second})
+	if _, err := Call(ctx, http.MethodGet, server.URL, nil, nil); err == nil || calls != 1 {
+		t.Fatalf("err %v calls %d, Retry-After beyond budget should stop retrying", err, calls)
+	}
+}
+
+func TestBreakerTransport(t *testing.T) {
+	var calls int
+	healthy := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if !healthy {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	breaker := &BreakerTransport{Next: HTTPTransport, Options: BreakerOptions{
+		MinRequests:      4,
+		CoolDown:         50 * time.Millisecond,
+		HalfOpenRequests: 1,
+	}}
+	client := &http.Client{Transport: breaker}
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	get := func() error {
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	for range 4 {
+		if err := get(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if state := breaker.State(host); state != StateOpen {
+		t.Fatalf("state = %s", state)
+	}
+
+	err := get()
+	var openErr *CircuitOpenError
+	if !errors.Is(err, ErrCircuitOpen) || !errors.As(err, &openErr) || openErr.Host != host || calls != 4 {
+		t.Fatalf("err %v calls %d", err, calls)
+	}
+
+	// 冷却后探测失败重新熔断
+	time.Sleep(60 * time.Millisecond)
+	if state := breaker.State(host); state != StateHalfOpen {
+		t.Fatalf("state = %s", state)
+	}
+	_ = get()
+	if state := breaker.State(host); state != StateOpen {
+		t.Fatalf("failed probe state = %s", state)
+	}
+
+	// 冷却后探测成功恢复
+	healthy = true
+	time.Sleep(60 * time.Millisecond)
+	if err = get(); err != nil {
+		t.Fatal(err)
+	}
+	if state := breaker.State(host); state != StateClosed {
+		t.Fatalf("recovered state = %s", state)
+	}
+}
+
+func TestBreakerMiddleware(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	if _, ok := HTTPClient.Transport.(*DecompressTransport).Next.(*BreakerTransport); ok {
+		t.Fatal("HTTPClient enables circuit breaker by default")
+	}
+
+	client := NewClient(BreakerMiddleware(BreakerOptions{Window: 20 * time.Millisecond}))
+	breaker := client.Transport.(*BreakerTransport)
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// 空闲超过两个窗口的 closed host 在下一次请求时回收
+	time.Sleep(50 * time.Millisecond)
+	breaker.mu.Lock()
+	breaker.hosts["idle.example"] = &hostBreaker{lastUsed: time.Now().Add(-time.Second)}
+	breaker.mu.Unlock()
+	time.Sleep(30 * time.Millisecond)
+	if resp, err = client.Get(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	if _, ok := breaker.hosts["idle.example"]; ok || len(breaker.hosts) != 1 {
+		t.Fatalf("hosts = %v", breaker.hosts)
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 1000))
+	modtime := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "data.bin", modtime, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	output := filepath.Join(t.TempDir(), "data.bin")
+	// 模拟上次下载中断
+	if err := os.WriteFile(output+".part", content[:4000], 0o664); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(output+".part.validator", []byte(`"v1"`), 0o664); err != nil {
+		t.Fatal(err)
+	}
+
+	var lastWritten, lastTotal int64
+	err := DownloadWithOptions(chain.Context(), server.URL, output, DownloadOptions{
+		Progress: func(written, total int64) { lastWritten, lastTotal = written, total },
+		MD5:      idhash.MD5(content),
+		SHA256:   fmt.Sprintf("%x", sha256.Sum256(content)),
+		Resume:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=4000-" {
+		t.Fatalf("ranges = %q", ranges)
+	}
+	if lastWritten != 10000 || lastTotal != 10000 {
+		t.Fatalf("progress %d/%d", lastWritten, lastTotal)
+	}
+	if data, _ := os.ReadFile(output); !bytes.Equal(data, content) {
+		t.Fatal("content mismatch")
+	}
+	if _, err = os.Stat(output + ".part"); !os.IsNotExist(err) {
+		t.Fatal("part file should be renamed")
+	}
+
+	// 校验失败不落地
+	other := filepath.Join(t.TempDir(), "other.bin")
+	err = DownloadWithOptions(chain.Context(), server.URL, other, DownloadOptions{MD5: idhash.MD5("x")})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("err = %v", err)
+	}
+	if found, _ := filedir.Exist(chain.BC, other); found {
+		t.Fatal("mismatched file should not exist")
+	}
+
+	err = DownloadWithOptions(chain.Context(), server.URL, other, DownloadOptions{MaxSize: 100})
+	if !errors.Is(err, ErrDownloadTooLarge) {
+		t.Fatalf("err = %v", err)
+	}
+	// 默认不续传, 失败不留下临时文件
+	if matches, _ := filepath.Glob(other + ".*"); len(matches) != 0 {
+		t.Fatalf("temp files left: %v", matches)
+	}
+
+	// 没有校验值的 .part 无法确认远端没有变化, 从头下载
+	ranges = nil
+	stale := filepath.Join(t.TempDir(), "stale.bin")
+	if err = os.WriteFile(stale+".part", []byte("stale"), 0o664); err != nil {
+		t.Fatal(err)
+	}
+	if err = DownloadWithOptions(chain.Context(), server.URL, stale, DownloadOptions{Resume: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(ranges) != 1 || ranges[0] != "" {
+		t.Fatalf("stale ranges = %q", ranges)
+	}
+	if data, _ := os.ReadFile(stale); !bytes.Equal(data, content) {
+		t.Fatal("stale content mismatch")
+	}
+}
+
+func TestDownloadConcurrent(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 10000))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// 不续传时每次下载使用独立临时文件, 同一路径并发下载不会互相覆盖
+	output := filepath.Join(t.TempDir(), "file.bin")
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Go(func() {
+			if err := Download(chain.Context(), server.URL, output); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	wg.Wait()
+	if data, _ := os.ReadFile(output); !bytes.Equal(data, content) {
+		t.Fatal("content mismatch")
+	}
+	if matches, _ := filepath.Glob(output + ".*"); len(matches) != 0 {
+		t.Fatalf("temp files left: %v", matches)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Error(err)
+			return
+		}
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer file.Close()
+		data, _ := io.ReadAll(file)
+
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"bucket":      r.FormValue("bucket"),
+			"filename":    header.Filename,
+			"contentType": header.Header.Get("Content-Type"),
+			"content":     string(data),
+			"trace":       r.Header.Get(chain.XRquestID),
+		})
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := os.WriteFile(path, []byte(`{"ok":true}`), 0o664); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := WithRetry(chain.WithContext(chain.BC, "trace-upload"), &RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, NonIdempotent: true})
+	var written, total int64
+	var result map[string]string
+	err := Upload(ctx, server.URL, UploadOptions{
+		Fields:   map[string]string{"bucket": "models"},
+		Files:    []UploadFile{{Field: "file", Path: path}},
+		Progress: func(w, t int64) { written, total = w, t },
+	}, &result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || written != 11 || total != 11 {
+		t.Fatalf("calls %d progress %d/%d", calls, written, total)
+	}
+	if result["bucket"] != "models" || result["filename"] != "report.json" || result["contentType"] != "application/json" ||
+		result["content"] != `{"ok":true}` || result["trace"] != "trace-upload" {
+		t.Fatalf("result = %v", result)
+	}
+
+	// 不可重读的 Reader 不重试
+	calls = 0
+	err = Upload(ctx, server.URL, UploadOptions{
+		Files: []UploadFile{{Field: "file", Name: "a.bin", Reader: io.LimitReader(strings.NewReader("abc"), 3)}},
+	}, nil)
+	if err == nil || calls != 1 {
+		t.Fatalf("err %v calls %d", err, calls)
+	}
+
+	// 第一次不读请求体直接失败, 重试前需要等上一次的写 goroutine 退出才能 Seek 同一个 Reader
+	content := bytes.Repeat([]byte("0123456789"), 100000)
+	var attempts atomic.Int64
+	quick := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer file.Close()
+		data, _ := io.ReadAll(file)
+		_ = json.NewEncoder(w).Encode(map[string]bool{"equal": bytes.Equal(data, content)})
+	}))
+	defer quick.Close()
+
+	var equal map[string]bool
+	err = Upload(ctx, quick.URL, UploadOptions{
+		Files: []UploadFile{{Field: "file", Name: "big.bin", Reader: bytes.NewReader(content)}},
+	}, &equal)
+	if err != nil || !equal["equal"] || attempts.Load() != 2 {
+		t.Fatalf("equal %v attempts %d err %v", equal, attempts.Load(), err)
+	}
+}
+
+func TestTransportMiddleware(t *testing.T) {
+	var tokens int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write([]byte(r.Header.Get(chain.XRquestID) + ":" + string(body)))
+	}))
+	defer server.Close()
+
+	source := CachedToken(func(ctx context.Context) (string, time.Duration, error) {
+		tokens++
+		return fmt.Sprintf("token-%d", tokens), time.Hour, nil
+	})
+	var metrics []TransportMetric
+	client := NewClient(
+		MetricsTransport(func(ctx context.Context, metric TransportMetric) { metrics = append(metrics, metric) }),
+		TraceTransport(),
+		LogTransport(4),
+		AuthTransport(source, "Bearer"),
+	)
+
+	ctx := chain.WithContext(chain.BC, "trace-rt")
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL, strings.NewReader("hello world"))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// 第一次 token-1 被拒绝, 刷新后 token-2 重放 body 成功, 日志截断不影响 body
+	if string(body) != "trace-rt:hello world" || tokens != 2 {
+		t.Fatalf("body %q tokens %d", body, tokens)
+	}
+	if len(metrics) != 1 || metrics[0].Status != http.StatusOK || metrics[0].Method != http.MethodPost {
+		t.Fatalf("metrics = %+v", metrics)
+	}
+}
+
+func TestLogTransportStream(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		fmt.Fprint(w, "{\"id\":1}\n")
+		w.(http.Flusher).Flush()
+		<-release
+		fmt.Fprint(w, "{\"id\":2}\n")
+	}))
+	defer server.Close()
+	defer close(release)
+
+	// 日志不能等 maxBody 字节到齐才返回响应, 否则流式响应会被阻塞
+	client := &http.Client{Transport: ChainTransport(HTTPTransport, LogTransport(1024)), Timeout: 2 * time.Second}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "{\"id\":1}\n" {
+		t.Fatalf("line %q err %v", line, err)
+	}
+}
+
+func TestFaultTransport(t *testing.T) {
+	client := &http.Client{Transport: ChainTransport(HTTPTransport, FaultTransport(FaultOptions{ErrorRate: 1}))}
+	if _, err := client.Get("http://127.0.0.1:1/"); !errors.Is(err, ErrFaultInjected) {
+		t.Fatalf("err = %v", err)
+	}
+
+	client = &http.Client{Transport: ChainTransport(HTTPTransport, FaultTransport(FaultOptions{StatusRate: 1, Status: http.StatusBadGateway}))}
+	resp, err := client.Get("http://127.0.0.1:1/")
+	if err != nil || resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("resp %v err %v", resp, err)
+	}
+	resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(chain.BC, 10*time.Millisecond)
+	defer cancel()
+	client = &http.Client{Transport: ChainTransport(HTTPTransport, FaultTransport(FaultOptions{Latency: time.Second}))}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://127.0.0.1:1/", nil)
+	if _, err = client.Do(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("latency err = %v", err)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=secret")
+		fmt.Fprintf(w, `{"method":%q,"echo":%q}`, r.Method, body)
+	}))
+
+	ctx := chain.WithContext(chain.BC, "trace-cassette")
+	type echo struct {
+		Method string `json:"method"`
+		Echo   string `json:"echo"`
+	}
+
+	for _, name := range []string{"partner.json", "partner.toml"} {
+		path := filepath.Join(t.TempDir(), name)
+
+		recorder, err := NewRecorder(RecorderOptions{Path: path, Mode: ModeRecord})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := HTTPClient
+		HTTPClient = recorder.Client()
+		var got echo
+		err = Post(ctx, server.URL+"/v1/echo", map[string]string{"Authorization": "Bearer secret"}, map[string]string{"a": "b"}, &got)
+		HTTPClient = client
+		if err != nil || got.Echo != `{"a":"b"}` {
+			t.Fatalf("record %s got %+v err %v", name, got, err)
+		}
+		if err = recorder.Save(); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := os.ReadFile(path)
+		if strings.Contains(string(data), "secret") {
+			t.Fatalf("cassette %s not redacted: %s", name, data)
+		}
+
+		recorder, err = NewRecorder(RecorderOptions{Path: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		HTTPClient = recorder.Client()
+		got = echo{}
+		err = Post(ctx, server.URL+"/v1/echo", nil, map[string]string{"a": "b"}, &got)
+		if err != nil || got.Method != http.MethodPost || got.Echo != `{"a":"b"}` {
+			HTTPClient = client
+			t.Fatalf("replay %s got %+v err %v", name, got, err)
+		}
+		if unused := recorder.Unused(); len(unused) != 0 {
+			t.Fatalf("unused = %+v", unused)
+		}
+
+		// 已用完或 body 不一致都视为未匹配
+		err = Post(ctx, server.URL+"/v1/echo", nil, map[string]string{"a": "c"}, &got)
+		HTTPClient = client
+		if !errors.Is(err, ErrCassetteUnmatched) {
+			t.Fatalf("unmatched %s err = %v", name, err)
+		}
+	}
+	server.Close()
+}
+
+func TestTypedJSON(t *testing.T) {
+	type item struct {
+		ID int `json:"id"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/item":
+			var req item
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			if r.Header.Get("Accept") != "application/json" || r.URL.RawQuery != "a=1&step=2" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			fmt.Fprintf(w, `{"id":%d}`, req.ID+1)
+		case "/html":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<html></html>")
+		case "/big":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"id":1,"pad":%q}`, strings.Repeat("x", 128))
+		case "/ndjson":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			fmt.Fprint(w, "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n")
+		case "/array":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, ` [{"id":1},{"id":2},{"id":3}]`)
+		case "/bigstream":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			fmt.Fprintf(w, "{\"id\":1}\n{\"id\":2,\"pad\":%q}\n", strings.Repeat("x", 128))
+		case "/broken":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[{"id":1},{"id":`)
+		}
+	}))
+	defer server.Close()
+
+	ctx := chain.WithContext(chain.BC, "trace-typed")
+	got, err := PostJSON[item, item](ctx, server.URL+"/item?a=1", nil, item{ID: 41}, WithQuery("step", "2"))
+	if err != nil || got.ID != 42 {
+		t.Fatalf("PostJSON %+v %v", got, err)
+	}
+	if _, err = GetJSON[item](ctx, server.URL+"/html", nil); !errors.Is(err, ErrContentType) {
+		t.Fatalf("content type err = %v", err)
+	}
+
+	if _, err = GetJSON[map[string]any](ctx, server.URL+"/big", nil, WithMaxResponseSize(64)); !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("size err = %v", err)
+	}
+	if _, err = GetJSON[map[string]any](ctx, server.URL+"/big", nil); err != nil {
+		t.Fatalf("default size err = %v", err)
+	}
+
+	for _, path := range []string{"/ndjson", "/array"} {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+path, nil)
+		var ids []int
+		// 上限按单个元素计算, 整个流超过上限也可以
+		for obj, err := range StreamJSON[item](ctx, req, WithMaxResponseSize(10)) {
+			if err != nil {
+				t.Fatalf("%s stream err %v", path, err)
+			}
+			ids = append(ids, obj.ID)
+			if len(ids) == 2 && path == "/array" {
+				// 提前 break 会关闭响应体
+				break
+			}
+		}
+		if (path == "/ndjson" && len(ids) != 3) || (path == "/array" && len(ids) != 2) {
+			t.Fatalf("%s ids = %v", path, ids)
+		}
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/bigstream", nil)
+	var ids []int
+	var streamErr error
+	for obj, err := range StreamJSON[item](ctx, req, WithMaxResponseSize(64)) {
+		if err != nil {
+			streamErr = err
+			break
+		}
+		ids = append(ids, obj.ID)
+	}
+	if len(ids) != 1 || !errors.Is(streamErr, ErrResponseTooLarge) {
+		t.Fatalf("bigstream ids %v err %v", ids, streamErr)
+	}
+
+	req, _ = http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/broken", nil)
+	ids, streamErr = nil, nil
+	for obj, err := range StreamJSON[item](ctx, req) {
+		if err != nil {
+			streamErr = err
+			break
+		}
+		ids = append(ids, obj.ID)
+	}
+	if len(ids) != 1 || streamErr == nil {
+		t.Fatalf("broken ids %v err %v", ids, streamErr)
+	}
+}
+
+func TestRequestOptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		user, pass, _ := r.BasicAuth()
+		w.Header().Set("X-Echo", r.URL.RawQuery)
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		if r.URL.Path == "/created" {
+			w.WriteHeader(http.StatusCreated)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"query": r.URL.RawQuery, "auth": r.Header.Get("Authorization"), "user": user + ":" + pass,
+			"name": r.PostForm.Get("name"), "type": r.Header.Get("Content-Type"),
+		})
+	}))
+	defer server.Close()
+
+	ctx := chain.WithContext(chain.BC, "trace-options")
+	var got map[string]string
+	var header http.Header
+	var status int
+	// url 中已有的 query 原样保留, 新参数按顺序追加
+	err := Request(ctx, http.MethodGet, server.URL+"/q?z=1&a=%2F", &got,
+		WithQuery("b", "2", "3"), WithQuery("a b", "&"), WithBearer("token"), WithResponseHeader(&header), WithStatus(&status))
+	want := "z=1&a=%2F&b=2&b=3&a+b=%26"
+	if err != nil || got["query"] != want || got["auth"] != "Bearer token" || header.Get("X-Echo") != want || status != http.StatusOK {
+		t.Fatalf("query got %v header %v status %d err %v", got, header, status, err)
+	}
+
+	err = Request(ctx, http.MethodPost, server.URL+"/form", &got, WithForm(map[string][]string{"name": {"sbp"}}), WithBasicAuth("u", "p"))
+	if err != nil || got["name"] != "sbp" || got["user"] != "u:p" || got["type"] != "application/x-www-form-urlencoded" {
+		t.Fatalf("form got %v err %v", got, err)
+	}
+
+	if err = Request(ctx, http.MethodGet, server.URL+"/slow", nil, WithTimeout(50*time.Millisecond)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("timeout err = %v", err)
+	}
+
+	if err = Request(ctx, http.MethodPost, server.URL+"/created", nil, WithExpectStatus(http.StatusOK)); err == nil {
+		t.Fatal("expect status 200 but 201 accepted")
+	}
+	data, err := RequestData(ctx, http.MethodPost, server.URL+"/created", WithExpectStatus(http.StatusCreated), WithClient(&http.Client{}))
+	if err != nil || !strings.Contains(string(data), `"query"`) {
+		t.Fatalf("created data %s err %v", data, err)
+	}
+}
@@ -0,0 +1,325 @@
+// Package httpiptest provides a fluent mock HTTP server for tests of code built on httpip.
+package httpiptest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// Server 声明式 mock 服务, 测试结束 (t.Cleanup) 时关闭并校验每个期望的调用次数
+//
+//	server := httpiptest.NewServer(t)
+//	server.On(http.MethodPost, "/v1/users").
+//		WithHeader("Authorization", "Bearer token").
+//		WithJSON(map[string]any{"name": "sbp"}).
+//		ReplyJSON(http.StatusCreated, map[string]any{"id": 1})
+//	err := httpip.Post(ctx, server.URL+"/v1/users", headers, request, &response)
+type Server struct {
+	*httptest.Server
+
+	t testing.TB
+
+	mu           sync.Mutex
+	expectations []*Expectation
+}
+
+// NewServer 启动 mock 服务, 未匹配任何期望的请求返回 501 并让测试失败
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+
+	server := &Server{t: t}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.serveHTTP))
+	t.Cleanup(func() {
+		server.Close()
+		server.Verify()
+	})
+	return server
+}
+
+// On 声明一个期望, path 不包含 query; 默认期望调用 1 次
+// 多个期望同时匹配时按声明顺序使用, 次数用完的期望不再匹配, 可以用来描述同一接口的多次不同响应
+func (s *Server) On(method, path string) *Expectation {
+	e := &Expectation{
+		method:  method,
+		path:    path,
+		times:   1,
+		status:  http.StatusOK,
+		headers: make(http.Header),
+	}
+
+	s.mu.Lock()
+	s.expectations = append(s.expectations, e)
+	s.mu.Unlock()
+	return e
+}
+
+// Verify 校验每个期望的调用次数, NewServer 已经在 t.Cleanup 中调用, 也可以提前主动调用
+func (s *Server) Verify() {
+	s.t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.expectations {
+		if e.times >= 0 && e.calls != e.times {
+			s.t.Errorf("httpiptest: %s called %d times, expected %d", e, e.calls, e.times)
+		}
+	}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	var matched *Expectation
+	var mismatches []string
+	for _, e := range s.expectations {
+		if e.times >= 0 && e.calls >= e.times {
+			continue
+		}
+		if r.Method != e.method || r.URL.Path != e.path {
+			continue
+		}
+		if reason := e.match(r, body); reason != "" {
+			mismatches = append(mismatches, e.String()+": "+reason)
+			continue
+		}
+		matched = e
+		matched.calls++
+		break
+	}
+	s.mu.Unlock()
+
+	if matched == nil {
+		s.t.Errorf("httpiptest: unexpected request %s %s body %q\n\t%s", r.Method, r.URL, body, strings.Join(mismatches, "\n\t"))
+		http.Error(w, "httpiptest: unexpected request "+r.Method+" "+r.URL.String(), http.StatusNotImplemented)
+		return
+	}
+	matched.serve(s.t, w, r, body)
+}
+
+// Expectation 一个期望的请求和对应的响应, 所有方法返回自身用于链式声明
+// 需要在发出请求之前声明完成, 不支持和请求并发修改
+type Expectation struct {
+	method string
+	path   string
+
+	matchHeaders http.Header
+	matchQuery   map[string]string
+	matchJSON    any
+	hasJSON      bool
+	matchers     []func(r *http.Request, body []byte) bool
+
+	times int // < 0 不限次数
+	calls int
+
+	status   int
+	headers  http.Header
+	body     []byte
+	template *template.Template
+	handler  http.HandlerFunc
+	delay    time.Duration
+	fail     bool
+}
+
+func (e *Expectation) String() string {
+	return e.method + " " + e.path
+}
+
+// WithHeader 要求请求 header key 等于 value
+func (e *Expectation) WithHeader(key, value string) *Expectation {
+	if e.matchHeaders == nil {
+		e.matchHeaders = make(http.Header)
+	}
+	e.matchHeaders.Add(key, value)
+	return e
+}
+
+// WithQuery 要求 query 参数 key 等于 value
+func (e *Expectation) WithQuery(key, value string) *Expectation {
+	if e.matchQuery == nil {
+		e.matchQuery = make(map[string]string)
+	}
+	e.matchQuery[key] = value
+	return e
+}
+
+// WithJSON 要求请求体和 v 按 JSON 语义相等, 和字段顺序 / 空白无关
+func (e *Expectation) WithJSON(v any) *Expectation {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic("httpiptest: WithJSON marshal: " + err.Error())
+	}
+	e.matchJSON, e.hasJSON = nil, true
+	_ = json.Unmarshal(data, &e.matchJSON)
+	return e
+}
+
+// Match 自定义匹配条件
+func (e *Expectation) Match(matcher func(r *http.Request, body []byte) bool) *Expectation {
+	e.matchers = append(e.matchers, matcher)
+	return e
+}
+
+// Times 期望调用 n 次
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// AnyTimes 不限调用次数, 也不要求一定被调用
+func (e *Expectation) AnyTimes() *Expectation {
+	e.times = -1
+	return e
+}
+
+// Reply 响应状态码和原始 body
+func (e *Expectation) Reply(status int, body string) *Expectation {
+	e.status, e.body, e.template = status, []byte(body), nil
+	return e
+}
+
+// ReplyJSON 响应状态码和 JSON body
+func (e *Expectation) ReplyJSON(status int, v any) *Expectation {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic("httpiptest: ReplyJSON marshal: " + err.Error())
+	}
+	e.headers.Set("Content-Type", "application/json")
+	e.status, e.body, e.template = status, data, nil
+	return e
+}
+
+// ReplyTemplate 用 text/template 渲染响应, 模板数据为 TemplateData
+//
+//	ReplyTemplate(200, `{"id":{{.JSON.id}},"trace":"{{.Header.Get "X-Request-Id"}}"}`)
+func (e *Expectation) ReplyTemplate(status int, text string) *Expectation {
+	e.status, e.body = status, nil
+	e.template = template.Must(template.New(e.String()).Parse(text))
+	return e
+}
+
+// ReplyHeader 设置响应 header
+func (e *Expectation) ReplyHeader(key, value string) *Expectation {
+	e.headers.Add(key, value)
+	return e
+}
+
+// ReplyFunc 自定义响应, 请求体已经读取, 需要时使用 body 参数
+func (e *Expectation) ReplyFunc(handler func(w http.ResponseWriter, r *http.Request, body []byte)) *Expectation {
+	e.handler = func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		handler(w, r, body)
+	}
+	return e
+}
+
+// Delay 响应前等待, 用于测试客户端超时; 客户端取消时提前结束
+func (e *Expectation) Delay(delay time.Duration) *Expectation {
+	e.delay = delay
+	return e
+}
+
+// Fail 不返回任何响应直接断开连接, 用于测试客户端对网络错误的处理
+func (e *Expectation) Fail() *Expectation {
+	e.fail = true
+	return e
+}
+
+// TemplateData ReplyTemplate 的模板数据
+type TemplateData struct {
+	Method string
+	Path   string
+	Query  map[string][]string
+	Header http.Header
+	Body   string
+	JSON   any // 请求体按 JSON 解析的结果, 不是 JSON 时为 nil
+}
+
+// match 检查 method / path 之外的条件, 返回不匹配的原因, 匹配时返回空
+func (e *Expectation) match(r *http.Request, body []byte) string {
+	for key, values := range e.matchHeaders {
+		for _, value := range values {
+			if !slices.Contains(r.Header.Values(key), value) {
+				return fmt.Sprintf("header %s = %q, expected %q", key, r.Header.Get(key), value)
+			}
+		}
+	}
+	query := r.URL.Query()
+	for key, value := range e.matchQuery {
+		if !slices.Contains(query[key], value) {
+			return fmt.Sprintf("query %s = %q, expected %q", key, query.Get(key), value)
+		}
+	}
+	if e.hasJSON {
+		var got any
+		if err := json.Unmarshal(body, &got); err != nil || !reflect.DeepEqual(got, e.matchJSON) {
+			return fmt.Sprintf("json body %s mismatch", body)
+		}
+	}
+	for _, matcher := range e.matchers {
+		if !matcher(r, body) {
+			return "custom matcher rejected"
+		}
+	}
+	return ""
+}
+
+func (e *Expectation) serve(t testing.TB, w http.ResponseWriter, r *http.Request, body []byte) {
+	if e.delay > 0 {
+		timer := time.NewTimer(e.delay)
+		select {
+		case <-r.Context().Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+
+	if e.fail {
+		if conn, _, err := http.NewResponseController(w).Hijack(); err == nil {
+			conn.Close()
+			return
+		}
+		panic(http.ErrAbortHandler)
+	}
+
+	if e.handler != nil {
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		e.handler(w, r)
+		return
+	}
+
+	data := e.body
+	if e.template != nil {
+		var buf bytes.Buffer
+		tdata := TemplateData{Method: r.Method, Path: r.URL.Path, Query: r.URL.Query(), Header: r.Header, Body: string(body)}
+		_ = json.Unmarshal(body, &tdata.JSON)
+		if err := e.template.Execute(&buf, tdata); err != nil {
+			t.Errorf("httpiptest: %s template error: %v", e, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data = buf.Bytes()
+	}
+
+	for key, values := range e.headers {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(e.status)
+	_, _ = w.Write(data)
+}
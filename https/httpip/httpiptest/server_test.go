@@ -0,0 +1,88 @@
+package httpiptest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wangzhione/sbp/chain"
+	"github.com/wangzhione/sbp/https/httpip"
+)
+
+// recordTB 收集 Errorf, 用于断言 mock 服务自身的失败报告
+type recordTB struct {
+	testing.TB
+
+	mu     sync.Mutex
+	errors []string
+}
+
+func (r *recordTB) Helper() {}
+
+func (r *recordTB) Errorf(format string, args ...any) {
+	r.mu.Lock()
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+	r.mu.Unlock()
+}
+
+func TestServer(t *testing.T) {
+	server := NewServer(t)
+	server.On(http.MethodPost, "/v1/users").
+		WithHeader("Authorization", "Bearer token").
+		WithJSON(map[string]any{"name": "sbp", "age": 3}).
+		ReplyTemplate(http.StatusCreated, `{"name":"{{.JSON.name}}","trace":"{{.Header.Get "X-Request-Id"}}"}`).
+		ReplyHeader("Content-Type", "application/json")
+	server.On(http.MethodGet, "/v1/users").WithQuery("page", "1").ReplyJSON(http.StatusOK, []int{1, 2}).Times(2)
+	server.On(http.MethodGet, "/v1/users").ReplyJSON(http.StatusOK, []int{}).AnyTimes()
+	server.On(http.MethodGet, "/slow").Delay(time.Second).AnyTimes()
+	server.On(http.MethodGet, "/broken").Fail()
+
+	ctx := chain.WithContext(chain.BC, "trace-mock")
+	var created map[string]string
+	err := httpip.Post(ctx, server.URL+"/v1/users", map[string]string{"Authorization": "Bearer token"}, map[string]any{"age": 3, "name": "sbp"}, &created)
+	if err != nil || created["name"] != "sbp" || created["trace"] != "trace-mock" {
+		t.Fatalf("created %v err %v", created, err)
+	}
+
+	for _, want := range []int{2, 2, 0, 0} {
+		var ids []int
+		if err = httpip.Get(ctx, server.URL+"/v1/users?page=1", nil, &ids); err != nil || len(ids) != want {
+			t.Fatalf("ids %v want %d err %v", ids, want, err)
+		}
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err = httpip.Get(timeout, server.URL+"/slow", nil, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("slow err = %v", err)
+	}
+	if err = httpip.Get(ctx, server.URL+"/broken", nil, nil); err == nil {
+		t.Fatal("broken connection without error")
+	}
+}
+
+func TestServerVerify(t *testing.T) {
+	tb := &recordTB{TB: t}
+	server := NewServer(tb)
+	server.On(http.MethodPut, "/v1/users/1").WithJSON(map[string]any{"name": "sbp"}).Times(2)
+
+	ctx := chain.WithContext(chain.BC, "trace-verify")
+	if err := httpip.Put(ctx, server.URL+"/v1/users/1", nil, map[string]any{"name": "sbp"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := httpip.Put(ctx, server.URL+"/v1/users/1", nil, map[string]any{"name": "other"}, nil); err == nil {
+		t.Fatal("unmatched body accepted")
+	}
+	server.Verify()
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if len(tb.errors) != 2 || !strings.Contains(tb.errors[0], "unexpected request") || !strings.Contains(tb.errors[1], "called 1 times, expected 2") {
+		t.Fatalf("errors = %q", tb.errors)
+	}
+}
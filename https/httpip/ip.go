@@ -3,29 +3,201 @@ package httpip
 import (
 	"net"
 	"net/http"
+	"net/netip"
 	"strings"
+	"sync/atomic"
 )
 
+// defaultIPResolver GetClientIP 使用的可信代理解析器, 为空表示没有可信代理
+var defaultIPResolver atomic.Pointer[IPResolver]
+
+// SetTrustedProxies 设置全局可信代理, 之后 GetClientIP 只信任这些代理追加的 X-Forwarded-For / Forwarded 记录
+// 推荐 main init 阶段调用, 不传参数表示清空可信代理
+//
+//	httpip.SetTrustedProxies("10.0.0.0/8", "172.16.0.0/12", "127.0.0.1")
+func SetTrustedProxies(cidrs ...string) error {
+	if len(cidrs) == 0 {
+		defaultIPResolver.Store(nil)
+		return nil
+	}
+
+	resolver, err := NewIPResolver(cidrs...)
+	if err != nil {
+		return err
+	}
+	defaultIPResolver.Store(resolver)
+	return nil
+}
+
+// DefaultIPResolver 返回 SetTrustedProxies 设置的解析器, 没有设置时返回 nil
+func DefaultIPResolver() *IPResolver {
+	return defaultIPResolver.Load()
+}
+
 // GetClientIP 获取客户端 ip
-func GetClientIP(r *http.Request) (ip string) {
-	// X-Forwarded-For: <client>, <proxy1>, <proxy2>
-	// X-Forwarded-For (XFF) 在客户端访问服务器的过程中如果需要经过 HTTP 代理或者负载均衡服务器,
-	// 可以被用来获取最初发起请求的客户端的 IP 地址, 这个消息首部成为事实上的标准.
-	xForwardedFor := strings.TrimSpace(r.Header.Get("X-Forwarded-For"))
-	if len(xForwardedFor) > 0 {
-		xForwardedFors := strings.Split(xForwardedFor, ",")
-		ip = strings.TrimSpace(xForwardedFors[0])
-		if len(ip) > 0 {
-			return
+// 没有 SetTrustedProxies 时不信任任何转发 header, 直接使用 RemoteAddr, 避免客户端伪造 X-Forwarded-For;
+// 服务部署在 LB / nginx 后面时, 需要 SetTrustedProxies 配置代理地址才能拿到真实客户端 ip
+func GetClientIP(r *http.Request) string {
+	resolver := defaultIPResolver.Load()
+	if resolver == nil {
+		// 空列表没有可信代理, ClientIP 只返回直连对端
+		resolver = &IPResolver{}
+	}
+	return resolver.ClientIP(r)
+}
+
+// IPResolver 基于可信代理列表解析客户端 ip
+// 只有直连对端 (RemoteAddr) 是可信代理时才读取转发 header, 并从右往左跳过可信代理,
+// 第一个不可信的地址就是客户端 ip, 客户端自己伪造的左侧记录不会被采信
+type IPResolver struct {
+	TrustedProxies []netip.Prefix
+}
+
+// NewIPResolver 创建可信代理解析器, cidrs 支持 "10.0.0.0/8" "::1/128" 也支持单个 ip "127.0.0.1"
+func NewIPResolver(cidrs ...string) (*IPResolver, error) {
+	resolver := &IPResolver{TrustedProxies: make([]netip.Prefix, 0, len(cidrs))}
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, err
+			}
+			addr = addr.Unmap().WithZone("")
+			resolver.TrustedProxies = append(resolver.TrustedProxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
 		}
+
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		resolver.TrustedProxies = append(resolver.TrustedProxies, prefix.Masked())
 	}
+	return resolver, nil
+}
+
+// Trusted addr 是否为可信代理
+func (resolver *IPResolver) Trusted(addr netip.Addr) bool {
+	for _, prefix := range resolver.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
 
-	// "X-Real-Ip" nginx 反向代理服务 IP
-	ip = strings.TrimSpace(r.Header.Get("X-Real-Ip"))
-	if len(ip) > 0 {
+// ClientIP 解析客户端 ip, 优先 RFC 7239 Forwarded, 其次 X-Forwarded-For, 最后 X-Real-Ip
+func (resolver *IPResolver) ClientIP(r *http.Request) string {
+	remote, ok := ParseIP(r.RemoteAddr)
+	if !ok {
+		// unix socket 等场景 RemoteAddr 不是 ip, 原样返回
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return r.RemoteAddr
+		}
+		return host
+	}
+	if !resolver.Trusted(remote) {
+		return remote.String()
+	}
+
+	hops := forwardedFor(r.Header)
+	if len(hops) == 0 {
+		hops = forwardedXFF(r.Header)
+	}
+	if len(hops) == 0 {
+		if addr, ok := ParseIP(r.Header.Get("X-Real-Ip")); ok {
+			return addr.String()
+		}
+		return remote.String()
+	}
+
+	// 从右往左, 每一跳都是上一个可信代理写入的
+	client := remote
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := ParseIP(hops[i])
+		if !ok {
+			// unknown / 混淆标识 / 非法值, 再往左的记录不可信, 停在最后一个可信代理
+			break
+		}
+		client = addr
+		if !resolver.Trusted(addr) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// ParseIP 解析 ip, 兼容 "1.2.3.4" "1.2.3.4:80" "[2001:db8::1]:80" "fe80::1%eth0" 等格式
+// 去掉 IPv6 zone id, ::ffff:1.2.3.4 这类 IPv4 映射地址还原为 IPv4
+func ParseIP(s string) (addr netip.Addr, ok bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
 		return
 	}
-	// 兜底直接使用 client 请求的 ip 地址
-	ip, _, _ = net.SplitHostPort(r.RemoteAddr)
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		host, _, splitErr := net.SplitHostPort(s)
+		if splitErr != nil {
+			// "[2001:db8::1]" 没有端口
+			host = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+		}
+		if addr, err = netip.ParseAddr(host); err != nil {
+			return
+		}
+	}
+
+	return addr.Unmap().WithZone(""), true
+}
+
+// forwardedXFF 合并多个 X-Forwarded-For header, 按出现顺序返回每一跳
+func forwardedXFF(header http.Header) (hops []string) {
+	for _, value := range header.Values("X-Forwarded-For") {
+		for hop := range strings.SplitSeq(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
 	return
 }
+
+// forwardedFor 解析 RFC 7239 Forwarded header 中每个 element 的 for 参数
+// Forwarded: for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8:cafe::17]:4711"
+func forwardedFor(header http.Header) (hops []string) {
+	for _, value := range header.Values("Forwarded") {
+		for _, element := range splitQuoted(value, ',') {
+			hop := ""
+			for _, pair := range splitQuoted(element, ';') {
+				key, val, found := strings.Cut(pair, "=")
+				if !found || !strings.EqualFold(strings.TrimSpace(key), "for") {
+					continue
+				}
+				hop = strings.Trim(strings.TrimSpace(val), `"`)
+			}
+			// 没有 for 参数的 element 也占一跳, 保证从右往左的位置正确
+			hops = append(hops, hop)
+		}
+	}
+	return
+}
+
+// splitQuoted 按 sep 切分, 忽略双引号内的 sep
+func splitQuoted(s string, sep byte) (parts []string) {
+	quoted := false
+	begin := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, strings.TrimSpace(s[begin:i]))
+				begin = i + 1
+			}
+		}
+	}
+	return append(parts, strings.TrimSpace(s[begin:]))
+}
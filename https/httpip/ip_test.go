@@ -0,0 +1,67 @@
+package httpip
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPResolver_ClientIP(t *testing.T) {
+	resolver, err := NewIPResolver("10.0.0.0/8", "127.0.0.1", "2001:db8:cafe::/48")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		remote string
+		header map[string]string
+		want   string
+	}{
+		{"untrusted remote ignores xff", "203.0.113.9:5000", map[string]string{"X-Forwarded-For": "1.1.1.1"}, "203.0.113.9"},
+		{"spoofed left entry", "10.0.0.2:5000", map[string]string{"X-Forwarded-For": "1.1.1.1, 198.51.100.7, 10.0.0.3"}, "198.51.100.7"},
+		{"all trusted", "127.0.0.1:5000", map[string]string{"X-Forwarded-For": "10.1.1.1, 10.0.0.3"}, "10.1.1.1"},
+		{"x-real-ip", "10.0.0.2:5000", map[string]string{"X-Real-Ip": "198.51.100.8"}, "198.51.100.8"},
+		{"forwarded ipv6", "10.0.0.2:5000", map[string]string{"Forwarded": `for=1.1.1.1, for="[2001:db8::17]:4711";proto=https`}, "2001:db8::17"},
+		{"forwarded unknown", "10.0.0.2:5000", map[string]string{"Forwarded": `for=1.1.1.1, for=unknown`}, "10.0.0.2"},
+		{"forwarded priority", "10.0.0.2:5000", map[string]string{"Forwarded": "for=198.51.100.1", "X-Forwarded-For": "198.51.100.2"}, "198.51.100.1"},
+		{"ipv6 remote zone", "[fe80::1%eth0]:5000", nil, "fe80::1"},
+		{"ipv4 mapped", "[::ffff:10.0.0.2]:5000", map[string]string{"X-Forwarded-For": "198.51.100.3"}, "198.51.100.3"},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remote
+		for key, value := range c.header {
+			r.Header.Set(key, value)
+		}
+
+		if got := resolver.ClientIP(r); got != c.want {
+			t.Errorf("%s: got %q want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestGetClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "203.0.113.9:5000"
+	r.Header.Set("X-Forwarded-For", "1.1.1.1")
+
+	// 默认不信任转发 header, 伪造的 X-Forwarded-For / X-Real-Ip 不生效
+	r.Header.Set("X-Real-Ip", "2.2.2.2")
+	if ip := GetClientIP(r); ip != "203.0.113.9" {
+		t.Fatalf("default GetClientIP: %q", ip)
+	}
+
+	if err := SetTrustedProxies("10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetTrustedProxies()
+
+	if ip := GetClientIP(r); ip != "203.0.113.9" {
+		t.Fatalf("untrusted remote GetClientIP: %q", ip)
+	}
+	r.RemoteAddr = "10.0.0.2:5000"
+	if ip := GetClientIP(r); ip != "1.1.1.1" {
+		t.Fatalf("trusted GetClientIP: %q", ip)
+	}
+}
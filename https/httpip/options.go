@@ -0,0 +1,269 @@
+package httpip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/wangzhione/sbp/chain"
+)
+
+// Option 单次调用配置, 用于 Request / RequestData / GetJSON / PostJSON 等
+//
+//	err := httpip.Request(ctx, http.MethodGet, url, &resp,
+//		httpip.WithTimeout(3*time.Second),
+//		httpip.WithQuery("page", "2"),
+//		httpip.WithBearer(token),
+//	)
+type Option func(*callOptions)
+
+type callOptions struct {
+	timeout     time.Duration
+	headers     http.Header
+	query       []string // 已编码的 key=value, 按调用顺序追加到 url 原有 query 之后
+	body        []byte
+	hasBody     bool
+	contentType string
+	client      *http.Client
+	expect      []int
+	respHeader  *http.Header
+	status      *int
+	basicUser   string
+	basicPass   string
+	basicAuth   bool
+	maxSize     int64 // 只用于 GetJSON / PostJSON / DoJSON / StreamJSON 等 JSON 解析
+	err         error
+}
+
+// DefaultMaxResponseSize GetJSON / PostJSON / DoJSON 响应体和 StreamJSON 单个元素的默认大小上限
+const DefaultMaxResponseSize int64 = 32 << 20
+
+// WithTimeout 单次调用超时, 包含重试和读取响应体
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *callOptions) { o.timeout = timeout }
+}
+
+// WithHeader 设置请求 header, 覆盖默认值
+func WithHeader(key, value string) Option {
+	return func(o *callOptions) { o.headers.Set(key, value) }
+}
+
+// WithHeaders 批量设置请求 header, 兼容老接口的 headers map
+func WithHeaders(headers map[string]string) Option {
+	return func(o *callOptions) {
+		for key, value := range headers {
+			o.headers.Set(key, value)
+		}
+	}
+}
+
+// WithQuery 追加 query 参数, url 中已有的 query 原样保留, 不重新编码和排序
+func WithQuery(key string, values ...string) Option {
+	return func(o *callOptions) {
+		for _, value := range values {
+			o.query = append(o.query, url.QueryEscape(key)+"="+url.QueryEscape(value))
+		}
+	}
+}
+
+// WithQueryValues 批量追加 query 参数, 同 WithQuery; 本次追加的参数按 key 排序
+func WithQueryValues(query url.Values) Option {
+	return func(o *callOptions) {
+		if len(query) > 0 {
+			o.query = append(o.query, query.Encode())
+		}
+	}
+}
+
+// WithBasicAuth 设置 Authorization: Basic
+func WithBasicAuth(username, password string) Option {
+	return func(o *callOptions) { o.basicUser, o.basicPass, o.basicAuth = username, password, true }
+}
+
+// WithBearer 设置 Authorization: Bearer {token}
+func WithBearer(token string) Option {
+	return WithHeader("Authorization", "Bearer "+token)
+}
+
+// WithJSON 请求体按 JSON 编码, Content-Type: application/json; request 为 nil 时只设置 Content-Type
+func WithJSON(request any) Option {
+	return func(o *callOptions) {
+		o.contentType = "application/json"
+		o.body, o.hasBody = nil, false
+		if request == nil {
+			return
+		}
+		// 编码错误在发送前返回
+		o.body, o.err = json.Marshal(request)
+		o.hasBody = o.err == nil
+	}
+}
+
+// WithForm 请求体按 application/x-www-form-urlencoded 编码
+func WithForm(form url.Values) Option {
+	return WithBody([]byte(form.Encode()), "application/x-www-form-urlencoded")
+}
+
+// WithBody 原始请求体, contentType 为空时不设置 Content-Type
+func WithBody(body []byte, contentType string) Option {
+	return func(o *callOptions) {
+		o.body, o.hasBody, o.contentType = body, len(body) > 0, contentType
+	}
+}
+
+// WithClient 使用自定义 http.Client, 默认 HTTPClient; 重试策略依旧生效
+func WithClient(client *http.Client) Option {
+	return func(o *callOptions) { o.client = client }
+}
+
+// WithExpectStatus 期望的状态码, 不在其中返回错误; 默认 2xx
+func WithExpectStatus(codes ...int) Option {
+	return func(o *callOptions) { o.expect = append(o.expect, codes...) }
+}
+
+// WithResponseHeader 保存响应 header, 状态码不符合期望时也会保存
+func WithResponseHeader(header *http.Header) Option {
+	return func(o *callOptions) { o.respHeader = header }
+}
+
+// WithMaxResponseSize JSON 响应体大小上限, StreamJSON 按单个元素计算; <= 0 不限制, 默认 DefaultMaxResponseSize
+// Request / RequestData 不受限制
+func WithMaxResponseSize(size int64) Option {
+	return func(o *callOptions) { o.maxSize = size }
+}
+
+// WithStatus 保存响应状态码
+func WithStatus(status *int) Option {
+	return func(o *callOptions) { o.status = status }
+}
+
+// Request 按 options 发起调用, 响应按 application/json 解析到 response, response 为 nil 时丢弃响应体
+func Request(ctx context.Context, method, url string, response any, options ...Option) error {
+	return do(ctx, method, url, options, func(resp *http.Response, _ *callOptions, err error) error {
+		if err != nil {
+			// 读完 resp.Body 增加链接复用可能
+			io.Copy(io.Discard, resp.Body)
+			return err
+		}
+		if response == nil {
+			io.Copy(io.Discard, resp.Body)
+			return nil
+		}
+		// 解析 JSON 响应流
+		return json.NewDecoder(resp.Body).Decode(response)
+	})
+}
+
+// RequestData 按 options 发起调用, 返回原始响应体; 状态码不符合期望时同时返回响应体和错误
+func RequestData(ctx context.Context, method, url string, options ...Option) (data []byte, err error) {
+	err = do(ctx, method, url, options, func(resp *http.Response, _ *callOptions, err error) error {
+		var readErr error
+		if data, readErr = io.ReadAll(resp.Body); readErr != nil {
+			return readErr
+		}
+		return err
+	})
+	return
+}
+
+// do 构造请求并发送, 由 read 读取响应体, err 为状态码不符合期望的错误
+func do(ctx context.Context, method, uri string, options []Option, read func(resp *http.Response, o *callOptions, err error) error) error {
+	o := newCallOptions(options)
+	if o.err != nil {
+		return o.err
+	}
+
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		defer cancel()
+	}
+
+	if len(o.query) > 0 {
+		uri = appendQuery(uri, strings.Join(o.query, "&"))
+	}
+
+	var body io.Reader
+	if o.hasBody {
+		body = bytes.NewReader(o.body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, uri, body)
+	if err != nil {
+		return err
+	}
+
+	// 设置默认 Content-Type X-Request-Id X-Request-Timeout, 调用方 header 优先
+	if o.contentType != "" {
+		req.Header.Set("Content-Type", o.contentType)
+	}
+	req.Header.Set(chain.XRquestID, chain.GetTraceID(ctx))
+	chain.SetDeadlineHeader(ctx, req.Header)
+	if o.basicAuth {
+		req.SetBasicAuth(o.basicUser, o.basicPass)
+	}
+	for key, values := range o.headers {
+		req.Header[key] = values
+	}
+
+	client := o.client
+	if client == nil {
+		client = HTTPClient
+	}
+	resp, err := sendClient(ctx, client, req)
+	if err != nil {
+		// 被动取消 case : errors.Is(err, context.Canceled)
+		// 超时错误 case : errors.Is(err, context.DeadlineExceeded)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if o.respHeader != nil {
+		*o.respHeader = resp.Header
+	}
+	if o.status != nil {
+		*o.status = resp.StatusCode
+	}
+
+	return read(resp, o, o.checkStatus(resp))
+}
+
+func newCallOptions(options []Option) *callOptions {
+	o := &callOptions{headers: make(http.Header), maxSize: DefaultMaxResponseSize}
+	for _, option := range options {
+		option(o)
+	}
+	return o
+}
+
+// appendQuery 把已编码的 query 追加到 uri 原有 query 之后, 保留 fragment
+func appendQuery(uri, query string) string {
+	base, fragment, hasFragment := strings.Cut(uri, "#")
+	switch {
+	case !strings.Contains(base, "?"):
+		base += "?"
+	case !strings.HasSuffix(base, "?") && !strings.HasSuffix(base, "&"):
+		base += "&"
+	}
+	base += query
+	if hasFragment {
+		base += "#" + fragment
+	}
+	return base
+}
+
+func (o *callOptions) checkStatus(resp *http.Response) error {
+	if len(o.expect) == 0 {
+		return HTTPResponseCodeError(resp)
+	}
+	if slices.Contains(o.expect, resp.StatusCode) {
+		return nil
+	}
+	return fmt.Errorf("error: HTTP Code %d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+}
@@ -0,0 +1,179 @@
+package httpip
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy 重试策略, 作用于 Do / DoRequest / Call / Data 及其衍生函数
+//
+//	ctx = httpip.WithRetry(ctx, &httpip.RetryPolicy{MaxAttempts: 3})
+//	err := httpip.Get(ctx, url, nil, &resp)
+type RetryPolicy struct {
+	MaxAttempts int           // 最大尝试次数, 包含第一次, <= 1 不重试
+	BaseDelay   time.Duration // 退避基数, 第 n 次重试等待 [0, BaseDelay * 2^n) 随机时长, 默认 100ms
+	MaxDelay    time.Duration // 单次等待上限, 默认 2s
+
+	// Budget 单次调用所有重试等待时间之和上限, 避免下游故障时重试风暴拖垮自己, 默认 5s
+	Budget time.Duration
+
+	// NonIdempotent 非幂等方法 (POST / PATCH) 也重试; 携带 Idempotency-Key header 的请求默认视为幂等
+	NonIdempotent bool
+}
+
+// DefaultRetryPolicy 全局默认重试策略, nil 表示不重试
+var DefaultRetryPolicy *RetryPolicy
+
+type retryKey struct{}
+
+// WithRetry 为 ctx 上的调用指定重试策略, policy 为 nil 表示禁用重试
+func WithRetry(ctx context.Context, policy *RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryKey{}, policy)
+}
+
+func retryPolicy(ctx context.Context) *RetryPolicy {
+	if policy, ok := ctx.Value(retryKey{}).(*RetryPolicy); ok {
+		return policy
+	}
+	return DefaultRetryPolicy
+}
+
+// retryable 请求方法是否允许重试
+func (policy *RetryPolicy) retryable(req *http.Request) bool {
+	if policy == nil || policy.MaxAttempts <= 1 {
+		return false
+	}
+	if policy.NonIdempotent || req.Header.Get("Idempotency-Key") != "" {
+		return true
+	}
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// backoff 指数退避 + full jitter
+func (policy *RetryPolicy) backoff(retry int) time.Duration {
+	base, ceiling := policy.BaseDelay, policy.MaxDelay
+	if base <= 0 {
+		base = 100 * time.Millisecond
+	}
+	if ceiling <= 0 {
+		ceiling = 2 * time.Second
+	}
+	// 先和 ceiling>>retry 比较再移位, BaseDelay 很大时 base<<retry 会溢出成负数
+	delay := ceiling
+	if retry >= 0 && retry < 30 && base <= ceiling>>retry {
+		delay = base << retry
+	}
+	if delay <= 0 {
+		return 1
+	}
+	return rand.N(delay) + 1
+}
+
+// send 按 ctx 上的重试策略通过 HTTPClient 发送请求
+func send(ctx context.Context, req *http.Request) (*http.Response, error) {
+	return sendClient(ctx, HTTPClient, req)
+}
+
+// sendClient 按 ctx 上的重试策略通过 client 发送请求, 需要重放的 body 通过 CloneRequest 复用
+func sendClient(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
+	policy := retryPolicy(ctx)
+	if !policy.retryable(req) {
+		return client.Do(req)
+	}
+
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	reqctx := req.Context()
+	return retryDo(ctx, req, policy, func() (*http.Response, error) {
+		return client.Do(CloneRequest(reqctx, req, body))
+	})
+}
+
+// retryDo 按 policy 重试, 每次 attempt 必须发出请求体完整可读的新请求; req 只用于 ctx 和日志
+func retryDo(ctx context.Context, req *http.Request, policy *RetryPolicy, attempt func() (*http.Response, error)) (*http.Response, error) {
+	budget := policy.Budget
+	if budget <= 0 {
+		budget = 5 * time.Second
+	}
+
+	reqctx := req.Context()
+	for i := 1; ; i++ {
+		resp, err := attempt()
+
+		var delay time.Duration
+		switch {
+		case err != nil:
+			// 调用方取消或超时不再重试, 熔断中重试没有意义
+			if reqctx.Err() != nil || errors.Is(err, ErrCircuitOpen) {
+				return nil, err
+			}
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+			delay = retryAfter(resp.Header.Get("Retry-After"))
+		case resp.StatusCode == http.StatusInternalServerError || resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusGatewayTimeout:
+		default:
+			return resp, nil
+		}
+
+		if i >= policy.MaxAttempts {
+			return resp, err
+		}
+		delay = max(delay, policy.backoff(i-1))
+		if delay > budget {
+			slog.WarnContext(ctx, "httpip retry budget exhausted", "method", req.Method, "url", req.URL.Redacted(), "attempt", i, "delay", delay, "budget", budget)
+			return resp, err
+		}
+		if deadline, ok := reqctx.Deadline(); ok && time.Until(deadline) < delay {
+			return resp, err
+		}
+		budget -= delay
+
+		if resp != nil {
+			slog.WarnContext(ctx, "httpip retry", "method", req.Method, "url", req.URL.Redacted(), "attempt", i, "status", resp.StatusCode, "delay", delay)
+			// 读完 resp.Body 增加链接复用可能
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		} else {
+			slog.WarnContext(ctx, "httpip retry", "method", req.Method, "url", req.URL.Redacted(), "attempt", i, "error", err, "delay", delay)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-reqctx.Done():
+			timer.Stop()
+			return nil, reqctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryAfter 解析 Retry-After, 支持秒数和 HTTP-date
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(0, time.Until(date))
+	}
+	return 0
+}
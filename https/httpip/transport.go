@@ -0,0 +1,347 @@
+package httpip
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wangzhione/sbp/chain"
+)
+
+// RoundTripperFunc 函数适配 http.RoundTripper
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// TransportMiddleware 客户端中间件, 和服务端 middleware.Middleware 对称
+type TransportMiddleware func(next http.RoundTripper) http.RoundTripper
+
+// ChainTransport 按顺序套用客户端中间件, ChainTransport(rt, a, b) 请求经过顺序 a -> b -> rt
+func ChainTransport(rt http.RoundTripper, middlewares ...TransportMiddleware) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		rt = middlewares[i](rt)
+	}
+	return rt
+}
+
+// UseTransport 在 HTTPClient 现有 Transport 外层追加中间件, 只应在初始化阶段调用
+func UseTransport(middlewares ...TransportMiddleware) {
+	HTTPClient.Transport = ChainTransport(HTTPClient.Transport, middlewares...)
+}
+
+// NewClient 基于 HTTPClient 的 Transport (解压 / 连接池) 构造独立 client, 中间件只作用于这个 client
+//
+//	client := httpip.NewClient(httpip.AuthTransport(tokens, "Bearer"), httpip.LogTransport(1024))
+func NewClient(middlewares ...TransportMiddleware) *http.Client {
+	return &http.Client{Transport: ChainTransport(HTTPClient.Transport, middlewares...)}
+}
+
+// TraceTransport 请求缺少 X-Request-Id / X-Request-Timeout 时按 req context 补齐, 适合直接使用 http.Client 的代码
+func TraceTransport() TransportMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			ctx := req.Context()
+			traceID := chain.GetTraceID(ctx)
+			_, hasDeadline := ctx.Deadline()
+			if (traceID != "" && req.Header.Get(chain.XRquestID) == "") || (hasDeadline && req.Header.Get(chain.XRequestTimeout) == "") {
+				// RoundTripper 不能修改入参 req
+				req = req.Clone(ctx)
+				if traceID != "" && req.Header.Get(chain.XRquestID) == "" {
+					req.Header.Set(chain.XRquestID, traceID)
+				}
+				chain.SetDeadlineHeader(ctx, req.Header)
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// LogTransport 记录请求和响应, body 最多记录 maxBody 字节, maxBody <= 0 不记录 body
+// 请求 body 前缀会拼回原始流; 响应 body 在调用方读取时顺带记录, 读到 EOF 或 Close 时输出日志, 不阻塞 SSE / NDJSON 等流式响应
+func LogTransport(maxBody int) TransportMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			ctx := req.Context()
+
+			var reqBody []byte
+			if maxBody > 0 && req.Body != nil && req.Body != http.NoBody {
+				var body io.ReadCloser
+				reqBody, body = peekBody(req.Body, maxBody)
+				req = req.Clone(ctx)
+				req.Body = body
+			}
+
+			begin := time.Now()
+			resp, err := next.RoundTrip(req)
+			elapsed := time.Since(begin)
+			if err != nil {
+				slog.ErrorContext(ctx, "httpip request error", "error", err, "method", req.Method, "url", req.URL.Redacted(),
+					"request", truncated(reqBody, maxBody), "elapsed", elapsed.String())
+				return resp, err
+			}
+
+			if maxBody <= 0 {
+				slog.InfoContext(ctx, "httpip request", "method", req.Method, "url", req.URL.Redacted(), "status", resp.StatusCode,
+					"elapsed", elapsed.String())
+				return resp, nil
+			}
+			resp.Body = &logBody{ReadCloser: resp.Body, max: maxBody, log: func(respBody []byte) {
+				slog.InfoContext(ctx, "httpip request", "method", req.Method, "url", req.URL.Redacted(), "status", resp.StatusCode,
+					"request", truncated(reqBody, maxBody), "response", truncated(respBody, maxBody),
+					"elapsed", elapsed.String(), "total", time.Since(begin).String())
+			}}
+			return resp, nil
+		})
+	}
+}
+
+// peekBody 读取最多 max+1 字节用于日志, 返回的 ReadCloser 仍然是完整内容; 只用于请求 body
+func peekBody(body io.ReadCloser, max int) ([]byte, io.ReadCloser) {
+	prefix, err := io.ReadAll(io.LimitReader(body, int64(max)+1))
+	rest := io.MultiReader(bytes.NewReader(prefix), body)
+	if err != nil {
+		rest = io.MultiReader(bytes.NewReader(prefix), errReader{err})
+	}
+	return prefix, struct {
+		io.Reader
+		io.Closer
+	}{rest, body}
+}
+
+// logBody 调用方读取时保存前 max+1 字节, 第一次遇到 EOF / 读错误或 Close 时回调 log
+type logBody struct {
+	io.ReadCloser
+	max int
+	log func(body []byte)
+
+	mu     sync.Mutex
+	prefix []byte
+	done   bool
+}
+
+func (b *logBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mu.Lock()
+	if keep := b.max + 1 - len(b.prefix); keep > 0 {
+		b.prefix = append(b.prefix, p[:min(n, keep)]...)
+	}
+	b.mu.Unlock()
+	if err != nil {
+		b.finish()
+	}
+	return n, err
+}
+
+func (b *logBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.finish()
+	return err
+}
+
+func (b *logBody) finish() {
+	b.mu.Lock()
+	if b.done {
+		b.mu.Unlock()
+		return
+	}
+	b.done = true
+	prefix := b.prefix
+	b.mu.Unlock()
+	b.log(prefix)
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
+
+func truncated(data []byte, max int) string {
+	if len(data) > max {
+		return string(data[:max]) + "...(truncated)"
+	}
+	return string(data)
+}
+
+// TransportMetric 单次请求指标
+type TransportMetric struct {
+	Method  string
+	Host    string
+	Status  int // 请求失败时为 0
+	Err     error
+	Elapsed time.Duration
+}
+
+// MetricsTransport 每个请求结束 (拿到响应头) 后回调 observe, 对接 prometheus 等指标系统
+func MetricsTransport(observe func(ctx context.Context, metric TransportMetric)) TransportMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			begin := time.Now()
+			resp, err := next.RoundTrip(req)
+
+			metric := TransportMetric{Method: req.Method, Host: req.URL.Host, Err: err, Elapsed: time.Since(begin)}
+			if resp != nil {
+				metric.Status = resp.StatusCode
+			}
+			observe(req.Context(), metric)
+			return resp, err
+		})
+	}
+}
+
+// TokenSource 提供鉴权 token, refresh 为 true 表示当前 token 已被服务端拒绝, 需要重新获取
+type TokenSource interface {
+	Token(ctx context.Context, refresh bool) (string, error)
+}
+
+// CachedToken 缓存 fetch 获取的 token, 到期前 10% 时间或被拒绝后重新获取, 并发调用只会触发一次 fetch
+func CachedToken(fetch func(ctx context.Context) (token string, expires time.Duration, err error)) TokenSource {
+	return &cachedToken{fetch: fetch}
+}
+
+type cachedToken struct {
+	fetch func(ctx context.Context) (string, time.Duration, error)
+
+	mu       sync.Mutex
+	token    string
+	expireAt time.Time
+}
+
+func (c *cachedToken) Token(ctx context.Context, refresh bool) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !refresh && c.token != "" && time.Now().Before(c.expireAt) {
+		return c.token, nil
+	}
+	token, expires, err := c.fetch(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "httpip token fetch error", "error", err)
+		return "", err
+	}
+	c.token, c.expireAt = token, time.Now().Add(expires-expires/10)
+	return token, nil
+}
+
+// AuthTransport 注入 Authorization: {scheme} {token}, 响应 401 时刷新 token 并重试一次
+// 请求体不可重放 (没有 GetBody) 时不重试
+func AuthTransport(source TokenSource, scheme string) TransportMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			ctx := req.Context()
+			token, err := source.Token(ctx, false)
+			if err != nil {
+				return nil, err
+			}
+
+			authed := req.Clone(ctx)
+			authed.Header.Set("Authorization", scheme+" "+token)
+			resp, err := next.RoundTrip(authed)
+			if err != nil || resp.StatusCode != http.StatusUnauthorized {
+				return resp, err
+			}
+
+			replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+			if !replayable {
+				return resp, nil
+			}
+			if token, err = source.Token(ctx, true); err != nil {
+				// 刷新失败返回原始 401
+				return resp, nil
+			}
+
+			retry := req.Clone(ctx)
+			if req.GetBody != nil {
+				if retry.Body, err = req.GetBody(); err != nil {
+					return resp, nil
+				}
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+
+			slog.InfoContext(ctx, "httpip token refreshed, retry", "method", req.Method, "url", req.URL.Redacted())
+			retry.Header.Set("Authorization", scheme+" "+token)
+			return next.RoundTrip(retry)
+		})
+	}
+}
+
+// ErrFaultInjected FaultTransport 注入的默认错误
+var ErrFaultInjected = errors.New("httpip: fault injected")
+
+// FaultOptions 故障注入配置, 用于测试超时 / 重试 / 熔断逻辑
+type FaultOptions struct {
+	Match func(req *http.Request) bool // 为空时作用于所有请求
+
+	Latency time.Duration // 发送前额外延迟, 受 req context 取消控制
+
+	ErrorRate float64 // [0, 1] 概率直接返回 Err
+	Err       error   // 默认 ErrFaultInjected
+
+	StatusRate float64 // [0, 1] 概率不发送请求, 直接返回 Status
+	Status     int     // 默认 503
+}
+
+// FaultTransport 故障注入中间件, 只应在测试或演练环境使用
+func FaultTransport(options FaultOptions) TransportMiddleware {
+	if options.Err == nil {
+		options.Err = ErrFaultInjected
+	}
+	if options.Status == 0 {
+		options.Status = http.StatusServiceUnavailable
+	}
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if options.Match != nil && !options.Match(req) {
+				return next.RoundTrip(req)
+			}
+
+			if options.Latency > 0 {
+				timer := time.NewTimer(options.Latency)
+				select {
+				case <-req.Context().Done():
+					timer.Stop()
+					return nil, req.Context().Err()
+				case <-timer.C:
+				}
+			}
+
+			if options.ErrorRate > 0 && rand.Float64() < options.ErrorRate {
+				closeRequestBody(req)
+				return nil, options.Err
+			}
+			if options.StatusRate > 0 && rand.Float64() < options.StatusRate {
+				closeRequestBody(req)
+				return &http.Response{
+					Status:        strconv.Itoa(options.Status) + " " + http.StatusText(options.Status),
+					StatusCode:    options.Status,
+					Proto:         "HTTP/1.1",
+					ProtoMajor:    1,
+					ProtoMinor:    1,
+					Header:        http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+					Body:          io.NopCloser(bytes.NewReader([]byte(http.StatusText(options.Status)))),
+					ContentLength: int64(len(http.StatusText(options.Status))),
+					Request:       req,
+				}, nil
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// closeRequestBody RoundTripper 约定即使出错也要关闭请求体
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
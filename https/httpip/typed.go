@@ -0,0 +1,230 @@
+package httpip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/wangzhione/sbp/chain"
+)
+
+var (
+	// ErrResponseTooLarge 响应体超过 WithMaxResponseSize 设置的上限
+	ErrResponseTooLarge = errors.New("httpip: response body too large")
+	// ErrContentType 响应 Content-Type 不是期望的 JSON 类型
+	ErrContentType = errors.New("httpip: unexpected response content type")
+)
+
+// GetJSON 发送 GET 请求, 响应按 JSON 解析为 T; options 同 Request
+//
+//	user, err := httpip.GetJSON[User](ctx, url, nil, httpip.WithTimeout(time.Second))
+func GetJSON[T any](ctx context.Context, url string, headers map[string]string, options ...Option) (T, error) {
+	return callJSON[T](ctx, http.MethodGet, url, headers, nil, options)
+}
+
+// PostJSON 发送 POST 请求, request 按 JSON 编码, 响应按 JSON 解析为 Resp
+func PostJSON[Req, Resp any](ctx context.Context, url string, headers map[string]string, request Req, options ...Option) (Resp, error) {
+	return callJSON[Resp](ctx, http.MethodPost, url, headers, request, options)
+}
+
+// PutJSON 发送 PUT 请求, 同 PostJSON
+func PutJSON[Req, Resp any](ctx context.Context, url string, headers map[string]string, request Req, options ...Option) (Resp, error) {
+	return callJSON[Resp](ctx, http.MethodPut, url, headers, request, options)
+}
+
+// DeleteJSON 发送 DELETE 请求, 同 GetJSON
+func DeleteJSON[T any](ctx context.Context, url string, headers map[string]string, options ...Option) (T, error) {
+	return callJSON[T](ctx, http.MethodDelete, url, headers, nil, options)
+}
+
+// callJSON 复用 do 构造请求, 默认 Accept: application/json, headers 和 options 优先
+func callJSON[T any](ctx context.Context, method, url string, headers map[string]string, request any, options []Option) (obj T, err error) {
+	options = append([]Option{WithHeader("Accept", "application/json"), WithJSON(request), WithHeaders(headers)}, options...)
+	err = do(ctx, method, url, options, func(resp *http.Response, o *callOptions, err error) error {
+		if err == nil {
+			err = checkContentType(resp)
+		}
+		if err != nil {
+			// 读完 resp.Body 增加链接复用可能
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			return err
+		}
+		return json.NewDecoder(newSizeLimiter(resp.Body, o.maxSize)).Decode(&obj)
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "httpip JSON call error", "error", err, "method", method)
+	}
+	return
+}
+
+// DoJSON 发送 req, 检查状态码 / Content-Type / 大小后把响应解析为 T
+// req 已经构造完成, options 只有 WithMaxResponseSize 生效
+func DoJSON[T any](ctx context.Context, req *http.Request, options ...Option) (obj T, err error) {
+	o := newCallOptions(options)
+
+	chain.SetDeadlineHeader(req.Context(), req.Header)
+	resp, err := send(ctx, req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if err = checkJSONResponse(resp); err != nil {
+		// 读完 resp.Body 增加链接复用可能
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		slog.ErrorContext(ctx, "DoJSON response error", "error", err, "method", req.Method, "url", req.URL.Redacted())
+		return
+	}
+
+	if err = json.NewDecoder(newSizeLimiter(resp.Body, o.maxSize)).Decode(&obj); err != nil {
+		slog.ErrorContext(ctx, "DoJSON decode error", "error", err, "method", req.Method, "url", req.URL.Redacted())
+	}
+	return
+}
+
+// StreamJSON 发送 req, 把 NDJSON (application/x-ndjson) 或 JSON 数组响应逐个元素解码为 T
+// 请求在第一次迭代时才发出, 提前 break 会关闭响应体; 出错时 yield 零值和 error 后结束
+// options 只有 WithMaxResponseSize 生效, 限制单个元素大小, 整个流不限制
+//
+//	for event, err := range httpip.StreamJSON[Event](ctx, req) {
+//		if err != nil { return err }
+//		...
+//	}
+func StreamJSON[T any](ctx context.Context, req *http.Request, options ...Option) iter.Seq2[T, error] {
+	o := newCallOptions(options)
+	return func(yield func(T, error) bool) {
+		var zero T
+
+		chain.SetDeadlineHeader(req.Context(), req.Header)
+		resp, err := send(ctx, req)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		defer resp.Body.Close()
+
+		if err = checkJSONResponse(resp); err != nil {
+			slog.ErrorContext(ctx, "StreamJSON response error", "error", err, "method", req.Method, "url", req.URL.Redacted())
+			yield(zero, err)
+			return
+		}
+
+		limiter := newSizeLimiter(resp.Body, o.maxSize)
+		decoder := json.NewDecoder(limiter)
+		if !decoder.More() {
+			// 空响应直接结束, 其他错误交给 Decode 返回
+			var obj T
+			if err = decoder.Decode(&obj); err != io.EOF {
+				yield(obj, err)
+			}
+			return
+		}
+
+		// 第一个 token 是 '[' 按数组逐个解码, 否则按多个连续 JSON 值 (NDJSON) 解码
+		array := isJSONArray(decoder)
+		if array {
+			if _, err = decoder.Token(); err != nil {
+				yield(zero, err)
+				return
+			}
+		}
+		for !array || decoder.More() {
+			// 每个元素从当前位置重新计算上限
+			start := decoder.InputOffset()
+			limiter.reset(start)
+
+			var obj T
+			if err = decoder.Decode(&obj); err != nil {
+				if err != io.EOF || array {
+					yield(zero, err)
+				}
+				return
+			}
+			if o.maxSize > 0 && decoder.InputOffset()-start > o.maxSize {
+				// 元素已经整体在缓冲区中, 读取时没有触发上限
+				yield(zero, fmt.Errorf("%w: element > %d", ErrResponseTooLarge, o.maxSize))
+				return
+			}
+			if !yield(obj, nil) {
+				return
+			}
+		}
+		// 数组结尾 ']'
+		if _, err = decoder.Token(); err != nil {
+			yield(zero, err)
+		}
+	}
+}
+
+// sizeLimiter 读取超过上限时返回 ErrResponseTooLarge, json.Decoder 会原样返回该错误
+type sizeLimiter struct {
+	r     io.Reader
+	max   int64 // <= 0 不限制
+	read  int64 // 已经从 r 读取的字节数
+	limit int64 // read 达到 limit 后报错
+}
+
+func newSizeLimiter(r io.Reader, max int64) *sizeLimiter {
+	return &sizeLimiter{r: r, max: max, limit: max}
+}
+
+// reset 从 offset 开始重新允许读取 max 字节, decoder 缓冲区中已经读取的部分也计算在内
+func (l *sizeLimiter) reset(offset int64) {
+	l.limit = offset + l.max
+}
+
+func (l *sizeLimiter) Read(p []byte) (int, error) {
+	if l.max <= 0 {
+		return l.r.Read(p)
+	}
+	remain := l.limit - l.read
+	if remain <= 0 {
+		return 0, fmt.Errorf("%w: > %d", ErrResponseTooLarge, l.max)
+	}
+	if int64(len(p)) > remain {
+		p = p[:remain]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	return n, err
+}
+
+// isJSONArray decoder.More 之后预读缓冲区第一个非空白字节, 不消费数据
+func isJSONArray(decoder *json.Decoder) bool {
+	data, _ := io.ReadAll(decoder.Buffered())
+	data = bytes.TrimLeft(data, " \t\r\n")
+	return len(data) > 0 && data[0] == '['
+}
+
+// checkJSONResponse 检查状态码和 Content-Type
+func checkJSONResponse(resp *http.Response) error {
+	if err := HTTPResponseCodeError(resp); err != nil {
+		return err
+	}
+	return checkContentType(resp)
+}
+
+// checkContentType Content-Type 需要是 JSON 类型, 为空时放行
+func checkContentType(resp *http.Response) error {
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		return nil
+	}
+	mediatype, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrContentType, contentType)
+	}
+	switch {
+	case mediatype == "application/json", mediatype == "application/x-ndjson", mediatype == "application/jsonl", strings.HasSuffix(mediatype, "+json"):
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrContentType, contentType)
+}
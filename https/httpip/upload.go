@@ -0,0 +1,223 @@
+package httpip
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/wangzhione/sbp/chain"
+)
+
+// UploadFile multipart 中的一个文件, Path / Open / Reader 三选一
+// Path 和 Open 每次重试重新打开, Reader 实现 io.Seeker 时重试前 Seek 回起点, 否则不能重试
+type UploadFile struct {
+	Field       string // 表单字段名
+	Name        string // 文件名, 为空时取 Path 的 base name
+	ContentType string // 为空时按扩展名推断, 兜底 application/octet-stream
+	Size        int64  // 用于进度 total, Path 自动获取, 未知填 0
+
+	Path   string
+	Open   func() (io.ReadCloser, error)
+	Reader io.Reader
+}
+
+// UploadOptions 上传配置
+type UploadOptions struct {
+	Method  string // 默认 POST
+	Headers map[string]string
+	Fields  map[string]string // 普通表单字段, 按字段名排序写在文件之前
+	Files   []UploadFile
+
+	// Progress 文件内容上传进度, total 为所有文件 Size 之和, 存在未知大小时为 -1; 重试时从 0 重新计数
+	Progress func(written, total int64)
+}
+
+// Upload 通过 io.Pipe + mime/multipart 流式上传表单和文件, 不会把文件读入内存
+// 响应按 application/json 解析到 response, response 为 nil 时丢弃响应体
+// 所有文件都可重读时遵循 WithRetry / DefaultRetryPolicy 重试 (POST 需要 NonIdempotent 或 Idempotency-Key)
+func Upload(ctx context.Context, url string, options UploadOptions, response any) error {
+	if options.Method == "" {
+		options.Method = http.MethodPost
+	}
+
+	options.Files = slices.Clone(options.Files)
+	total := int64(0)
+	for i := range options.Files {
+		file := &options.Files[i]
+		if file.Name == "" {
+			file.Name = filepath.Base(file.Path)
+		}
+		if file.Size <= 0 && file.Path != "" {
+			if info, err := os.Stat(file.Path); err == nil {
+				file.Size = info.Size()
+			}
+		}
+		if file.Size <= 0 || total < 0 {
+			total = -1
+		} else {
+			total += file.Size
+		}
+	}
+
+	// newRequest 每次构造新的 pipe 和写 goroutine; stop 关闭读端并等待写 goroutine 退出
+	// 之后才能重新打开 / Seek 同一个 UploadFile.Reader, 避免和上一次尝试并发读写
+	newRequest := func() (*http.Request, func(), error) {
+		pr, pw := io.Pipe()
+		writer := multipart.NewWriter(pw)
+
+		req, err := http.NewRequestWithContext(ctx, options.Method, url, pr)
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set(chain.XRquestID, chain.GetTraceID(ctx))
+		chain.SetDeadlineHeader(ctx, req.Header)
+		for key, value := range options.Headers {
+			req.Header.Set(key, value)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			pw.CloseWithError(writeMultipart(writer, options, total))
+		}()
+		stop := func() {
+			pr.Close()
+			<-done
+		}
+		return req, stop, nil
+	}
+
+	req, stop, err := newRequest()
+	if err != nil {
+		return err
+	}
+	defer func() { stop() }()
+
+	var resp *http.Response
+	if policy := retryPolicy(ctx); policy.retryable(req) && uploadReplayable(options.Files) {
+		next := req
+		resp, err = retryDo(ctx, req, policy, func() (*http.Response, error) {
+			// 第一次直接发送 req, 之后每次重新打开文件构造新的 pipe
+			if next == nil {
+				stop()
+				var err error
+				if next, stop, err = newRequest(); err != nil {
+					stop = func() {}
+					return nil, err
+				}
+			}
+			current := next
+			next = nil
+			return HTTPClient.Do(current)
+		})
+	} else {
+		resp, err = HTTPClient.Do(req)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Upload error", "error", err, "url", url)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err = HTTPResponseCodeError(resp); err != nil {
+		// 读完 resp.Body 增加链接复用可能
+		io.Copy(io.Discard, resp.Body)
+		slog.ErrorContext(ctx, "Upload HTTPResponseCodeError error", "error", err, "url", url)
+		return err
+	}
+	if response == nil {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// uploadReplayable 所有文件都能重新读取时才允许重试
+func uploadReplayable(files []UploadFile) bool {
+	for _, file := range files {
+		if file.Path == "" && file.Open == nil {
+			if _, ok := file.Reader.(io.Seeker); !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// writeMultipart 运行在独立 goroutine, 返回的错误会传递给请求体读取方
+func writeMultipart(writer *multipart.Writer, options UploadOptions, total int64) error {
+	for _, key := range slices.Sorted(maps.Keys(options.Fields)) {
+		if err := writer.WriteField(key, options.Fields[key]); err != nil {
+			return err
+		}
+	}
+
+	progress := &progressWriter{total: total, progress: options.Progress}
+	for _, file := range options.Files {
+		if err := writeMultipartFile(writer, file, progress); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func writeMultipartFile(writer *multipart.Writer, file UploadFile, progress *progressWriter) error {
+	source, err := openUploadFile(file)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	contentType := file.ContentType
+	if contentType == "" {
+		if contentType = mime.TypeByExtension(filepath.Ext(file.Name)); contentType == "" {
+			contentType = "application/octet-stream"
+		}
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, escapeQuotes(file.Field), escapeQuotes(file.Name)))
+	header.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return err
+	}
+
+	progress.w = part
+	_, err = io.Copy(progress, source)
+	return err
+}
+
+func openUploadFile(file UploadFile) (io.ReadCloser, error) {
+	switch {
+	case file.Path != "":
+		return os.Open(file.Path)
+	case file.Open != nil:
+		return file.Open()
+	case file.Reader != nil:
+		if seeker, ok := file.Reader.(io.Seeker); ok {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+		return io.NopCloser(file.Reader), nil
+	}
+	return nil, fmt.Errorf("httpip: upload file %q without source", file.Field)
+}
+
+// escapeQuotes 同 mime/multipart 内部实现
+func escapeQuotes(s string) string {
+	return strings.NewReplacer("\\", "\\\\", `"`, "\\\"").Replace(s)
+}
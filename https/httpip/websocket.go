@@ -0,0 +1,34 @@
+package httpip
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/wangzhione/sbp/chain"
+	"github.com/wangzhione/sbp/https/websocket"
+)
+
+// DialWebSocket 连接 ws:// 或 wss:// 服务, 复用 HTTPTransport 的拨号和 TLS 配置, 自动携带 X-Request-Id
+// opts 为 nil 使用默认参数, 长连接推荐设置 PingInterval 保活
+func DialWebSocket(ctx context.Context, url string, headers map[string]string, opts *websocket.Options) (*websocket.Conn, error) {
+	dialer := &websocket.Dialer{
+		NetDial:   HTTPTransport.DialContext,
+		TLSConfig: HTTPTransport.TLSClientConfig,
+	}
+	if opts != nil {
+		dialer.Options = *opts
+	}
+
+	header := make(http.Header, len(headers)+1)
+	header.Set(chain.XRquestID, chain.GetTraceID(ctx))
+	chain.SetDeadlineHeader(ctx, header)
+	for key, value := range headers {
+		header.Set(key, value)
+	}
+
+	conn, resp, err := dialer.Dial(ctx, url, header)
+	if resp != nil && err != nil {
+		resp.Body.Close()
+	}
+	return conn, err
+}
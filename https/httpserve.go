@@ -3,7 +3,9 @@ package https
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -45,28 +47,163 @@ func End(ctx context.Context) {
 
 type StopFunc func(ctx context.Context) <-chan struct{}
 
+// ServeOptions http.Server 启动参数
+type ServeOptions struct {
+	Addr     string       // 监听地址, tcp 类似 "0.0.0.0:8080", unix 类似 "/run/app.sock"; tcp 为空时同 ListenAndServe 默认 ":http" / ":https"
+	Network  string       // "tcp" (默认) "tcp4" "tcp6" "unix"
+	Listener net.Listener // 已经打开的 listener (systemd socket activation 等), 不为 nil 时忽略 Addr / Network
+
+	ReadHeaderTimeout time.Duration // 读取请求头超时, 防 slowloris
+	ReadTimeout       time.Duration // 读取整个请求 (含 body) 超时, 0 不限制, 大文件上传需要谨慎设置
+	WriteTimeout      time.Duration // 写响应超时, SSE / 长轮询这类流式接口需要在 handler 里用 http.ResponseController 单独放开
+	IdleTimeout       time.Duration // keep-alive 空闲连接超时
+	MaxHeaderBytes    int           // 请求头最大字节数
+
+	H2C          bool // 明文 HTTP/2 (h2c), 适合 LB / service mesh 到服务之间走 HTTP/2
+	LogConnState bool // Debug 级别记录连接状态变化, 排查连接问题时临时打开
+
+	CertFile string // 和 KeyFile 同时不为空时启用 HTTPS
+	KeyFile  string
+
+	StopTime time.Duration // 优雅退出最长等待时间
+}
+
+// DefaultServeOptions ServeLoop / ServeLoopTLS 使用的默认参数
+// ReadTimeout / WriteTimeout 默认不设置, 避免误伤大文件上传, SSE / 大文件下载等流式请求; slowloris 由 ReadHeaderTimeout 防护
+var DefaultServeOptions = ServeOptions{
+	Network:           "tcp",
+	ReadHeaderTimeout: 10 * time.Second,
+	IdleTimeout:       120 * time.Second,
+	MaxHeaderBytes:    http.DefaultMaxHeaderBytes,
+	StopTime:          10 * time.Second,
+}
+
+// NewServer 按 options 构建 http.Server, 不启动监听
+func NewServer(ctx context.Context, options ServeOptions, handler http.Handler) *http.Server {
+	serve := &http.Server{
+		Addr:              options.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: options.ReadHeaderTimeout,
+		ReadTimeout:       options.ReadTimeout,
+		WriteTimeout:      options.WriteTimeout,
+		IdleTimeout:       options.IdleTimeout,
+		MaxHeaderBytes:    options.MaxHeaderBytes,
+		// net/http 内部错误 (TLS 握手失败, 读取请求头失败等) 也走 slog
+		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+
+	if options.H2C {
+		protocols := new(http.Protocols)
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+		serve.Protocols = protocols
+	}
+
+	if options.LogConnState {
+		serve.ConnState = func(conn net.Conn, state http.ConnState) {
+			slog.DebugContext(ctx, "Server ConnState", slog.String("remote", conn.RemoteAddr().String()), slog.String("state", state.String()))
+		}
+	}
+
+	return serve
+}
+
+// Listen 按 options 打开 listener, unix socket 会先清理上次异常退出遗留的 socket 文件
+// 只有 socket 文件连接被拒绝 (没有进程在监听) 时才删除, 不会抢占正在运行实例的地址
+// tcp Addr 为空时和 http.Server.ListenAndServe 一致监听 ":http", 配置了 TLS 时监听 ":https", 不会随机端口
+func Listen(options ServeOptions) (net.Listener, error) {
+	if options.Listener != nil {
+		return options.Listener, nil
+	}
+
+	network, addr := options.Network, listenAddr(options)
+	if network == "" {
+		network = "tcp"
+	}
+	if network == "unix" {
+		removeStaleSocket(addr)
+	}
+	return net.Listen(network, addr)
+}
+
+// listenAddr tcp 空地址按是否启用 TLS 补齐默认端口
+func listenAddr(options ServeOptions) string {
+	if options.Addr != "" || options.Network == "unix" {
+		return options.Addr
+	}
+	if options.CertFile != "" && options.KeyFile != "" {
+		return ":https"
+	}
+	return ":http"
+}
+
+// removeStaleSocket 删除没有进程监听的遗留 socket 文件
+func removeStaleSocket(addr string) {
+	info, err := os.Stat(addr)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+
+	conn, err := net.DialTimeout("unix", addr, time.Second)
+	if err == nil {
+		// 有进程正在监听, 交给 net.Listen 返回 address already in use
+		conn.Close()
+		return
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		_ = os.Remove(addr)
+	}
+}
+
 // ServeLoop 服务启动 loop 主流程
 // addr 类似 fmt.Sprintf("0.0.0.0:%d", config.G.Serve.Port) ; 0.0.0.0 默认 ipv4 绑定本机地址
 // handler 类似 middleware.MainMiddleware(http.DefaultServeMux)
 func ServeLoop(ctx context.Context, addr string, handler http.Handler, stopTime time.Duration, stopmainfunc ...StopFunc) {
-	serve := &http.Server{
-		Addr:    addr,
-		Handler: handler,
+	options := DefaultServeOptions
+	options.Addr = addr
+	options.StopTime = stopTime
+	ServeLoopOptions(ctx, options, handler, stopmainfunc...)
+}
+
+// ServeLoopOptions 服务启动 loop 主流程, 支持超时, h2c, unix socket, 已打开的 listener 和 TLS
+func ServeLoopOptions(ctx context.Context, options ServeOptions, handler http.Handler, stopmainfunc ...StopFunc) {
+	serve := NewServer(ctx, options, handler)
+	tls := options.CertFile != "" && options.KeyFile != ""
+
+	listener, err := Listen(options)
+	if err != nil {
+		slog.ErrorContext(ctx, "Server Listen failed error",
+			slog.Any("error", err),
+			slog.String("network", options.Network),
+			slog.String("addr", options.Addr),
+		)
+		return
 	}
+	addr := listener.Addr().String()
 
-	go ServeShutdown(ctx, serve, stopTime, stopmainfunc...)
+	go ServeShutdown(ctx, serve, options.StopTime, stopmainfunc...)
 
 	// main server 启动
-	slog.InfoContext(ctx, "Server running", slog.String("addr", serve.Addr))
-	err := serve.ListenAndServe()
+	slog.InfoContext(ctx, "Server running",
+		slog.String("addr", addr),
+		slog.String("network", listener.Addr().Network()),
+		slog.Bool("tls", tls),
+		slog.Bool("h2c", options.H2C),
+	)
+	if tls {
+		err = serve.ServeTLS(listener, options.CertFile, options.KeyFile)
+	} else {
+		err = serve.Serve(listener)
+	}
 	if err != nil {
 		if err == http.ErrServerClosed {
-			slog.InfoContext(ctx, "Server success stop", slog.String("addr", serve.Addr))
+			slog.InfoContext(ctx, "Server success stop", slog.String("addr", addr))
 			return
 		}
-		slog.ErrorContext(ctx, "Server ListenAndServe failed error",
+		slog.ErrorContext(ctx, "Server Serve failed error",
 			slog.Any("error", err),
-			slog.String("addr", serve.Addr),
+			slog.String("addr", addr),
 		)
 	}
 }
@@ -142,23 +279,10 @@ func ServeShutdown(ctx context.Context, server *http.Server, stopTime time.Durat
 // handler 类似 middleware.MainMiddleware(http.DefaultServeMux)
 // 若 certFile 和 keyFile 不为空，则启用 HTTPS
 func ServeLoopTLS(ctx context.Context, certFile, keyFile, addr string, handler http.Handler, stopTime time.Duration, stopmainfunc ...StopFunc) {
-	serve := &http.Server{
-		Addr:    addr,
-		Handler: handler,
-	}
-
-	go ServeShutdown(ctx, serve, stopTime, stopmainfunc...)
-
-	slog.InfoContext(ctx, "🔒 HTTPS Server running", slog.String("addr", serve.Addr))
-	err := serve.ListenAndServeTLS(certFile, keyFile)
-	if err != nil {
-		if err == http.ErrServerClosed {
-			slog.InfoContext(ctx, "HTTPS Server success stop", slog.String("addr", serve.Addr))
-			return
-		}
-		slog.ErrorContext(ctx, "HTTPS Server ListenAndServeTLS failed error",
-			slog.Any("error", err),
-			slog.String("addr", serve.Addr),
-		)
-	}
+	options := DefaultServeOptions
+	options.Addr = addr
+	options.CertFile = certFile
+	options.KeyFile = keyFile
+	options.StopTime = stopTime
+	ServeLoopOptions(ctx, options, handler, stopmainfunc...)
 }
@@ -0,0 +1,107 @@
+package https
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wangzhione/sbp/chain"
+)
+
+func TestNewServerH2CUnix(t *testing.T) {
+	options := DefaultServeOptions
+	options.Network = "unix"
+	options.Addr = filepath.Join(t.TempDir(), "sbp.sock")
+	options.H2C = true
+	options.LogConnState = true
+
+	serve := NewServer(chain.BC, options, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto)
+	}))
+	if serve.ReadHeaderTimeout != 10*time.Second || serve.MaxHeaderBytes != http.DefaultMaxHeaderBytes {
+		t.Fatalf("unexpected server options: %+v", serve)
+	}
+
+	listener, err := Listen(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serve.Serve(listener)
+	defer serve.Shutdown(context.Background())
+
+	// 明文 HTTP/2 客户端, 通过 unix socket 连接
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{
+		Protocols: protocols,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", options.Addr)
+		},
+	}}
+
+	resp, err := client.Get("http://unix/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	if string(data) != "HTTP/2.0" {
+		t.Fatalf("unexpected proto: %q", data)
+	}
+}
+
+func TestListenUnixSocket(t *testing.T) {
+	options := ServeOptions{Network: "unix", Addr: filepath.Join(t.TempDir(), "live.sock")}
+	live, err := Listen(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+
+	// 正在监听的 socket 不能被抢占
+	if second, err := Listen(options); err == nil {
+		second.Close()
+		t.Fatal("listen on a live unix socket")
+	}
+	if conn, err := net.Dial("unix", options.Addr); err != nil {
+		t.Fatalf("live socket removed: %v", err)
+	} else {
+		conn.Close()
+	}
+
+	// 遗留的 socket 文件 (没有进程监听) 被清理后重新监听
+	stale := ServeOptions{Network: "unix", Addr: filepath.Join(t.TempDir(), "stale.sock")}
+	listener, err := net.Listen("unix", stale.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+
+	listener, err = Listen(stale)
+	if err != nil {
+		t.Fatalf("stale socket not removed: %v", err)
+	}
+	listener.Close()
+}
+
+func TestListenAddr(t *testing.T) {
+	for _, c := range []struct {
+		options ServeOptions
+		want    string
+	}{
+		{ServeOptions{}, ":http"},
+		{ServeOptions{CertFile: "cert.pem", KeyFile: "key.pem"}, ":https"},
+		{ServeOptions{Addr: "127.0.0.1:8080"}, "127.0.0.1:8080"},
+		{ServeOptions{Network: "unix", Addr: "/run/app.sock"}, "/run/app.sock"},
+	} {
+		if got := listenAddr(c.options); got != c.want {
+			t.Errorf("listenAddr(%+v) = %q, want %q", c.options, got, c.want)
+		}
+	}
+}
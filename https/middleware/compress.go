@@ -0,0 +1,271 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CompressOptions 压缩配置
+type CompressOptions struct {
+	// Level 压缩等级, 0 使用 gzip.DefaultCompression, 其他取值范围 gzip.HuffmanOnly ~ gzip.BestCompression
+	Level int
+	// MinSize 响应体小于该值不压缩, 默认 1024; 中途 Flush 的流式响应不受限制
+	MinSize int
+	// SkipTypes 不压缩的 Content-Type, 以 / 结尾表示前缀匹配, 为空使用 DefaultCompressSkipTypes
+	SkipTypes []string
+}
+
+// DefaultCompressSkipTypes 本身已经压缩或压缩收益很低的类型
+var DefaultCompressSkipTypes = []string{
+	"image/", "video/", "audio/", "font/woff", "font/woff2",
+	"application/zip", "application/gzip", "application/x-gzip", "application/x-7z-compressed",
+	"application/x-rar-compressed", "application/x-bzip2", "application/x-xz", "application/zstd",
+	"application/pdf", "application/octet-stream", "text/event-stream",
+}
+
+// Compress 根据 Accept-Encoding 协商 gzip / deflate 压缩响应体
+// Level 不合法时 panic, 配置错误在启动阶段暴露, 而不是请求中池里拿到 nil 编码器
+func Compress(opts ...CompressOptions) Middleware {
+	var options CompressOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+	if options.Level == 0 {
+		options.Level = gzip.DefaultCompression
+	}
+	if options.Level < gzip.HuffmanOnly || options.Level > gzip.BestCompression {
+		panic("middleware: Compress invalid Level " + strconv.Itoa(options.Level))
+	}
+	if options.MinSize <= 0 {
+		options.MinSize = 1024
+	}
+	if len(options.SkipTypes) == 0 {
+		options.SkipTypes = DefaultCompressSkipTypes
+	}
+
+	// 编码器按 middleware 实例池化, 同一个池里的 Level 一致
+	pools := map[string]*sync.Pool{
+		"gzip": {New: func() any {
+			w, _ := gzip.NewWriterLevel(io.Discard, options.Level)
+			return w
+		}},
+		"deflate": {New: func() any {
+			w, _ := zlib.NewWriterLevel(io.Discard, options.Level)
+			return w
+		}},
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			// HEAD 没有 body, Upgrade 会 Hijack, Range 响应压缩后偏移语义错乱
+			if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" || r.Header.Get("Range") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: w,
+				options:        &options,
+				encoding:       encoding,
+				pool:           pools[encoding],
+			}
+			defer cw.close()
+
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding 解析 Accept-Encoding q 值, 同权重优先 gzip
+func negotiateEncoding(accept string) string {
+	best, bestQ := "", 0.0
+	star := -1.0
+	seen := map[string]float64{}
+
+	for part := range strings.SplitSeq(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		if name == "*" {
+			star = q
+			continue
+		}
+		seen[name] = q
+	}
+
+	for _, name := range []string{"gzip", "deflate"} {
+		q, ok := seen[name]
+		if !ok {
+			q = max(star, 0)
+		}
+		if q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressWriter 先缓冲 MinSize 字节再决定是否压缩
+type compressWriter struct {
+	http.ResponseWriter
+	options  *CompressOptions
+	encoding string
+	pool     *sync.Pool
+
+	status  int
+	buf     []byte
+	decided bool
+	encoder compressor
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status != 0 {
+		// 重复调用, 和 net/http 一样忽略
+		return
+	}
+	// 1xx 信息性响应直接透传
+	if status < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+
+	// 没有 body 的状态码, 或 handler 明确给出的长度很小, 不需要等待 Write 就可以决定
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		cw.decide(false)
+		return
+	}
+	if length, err := strconv.Atoi(cw.Header().Get("Content-Length")); err == nil && length < cw.options.MinSize {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(data []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.encoder != nil {
+			return cw.encoder.Write(data)
+		}
+		return cw.ResponseWriter.Write(data)
+	}
+
+	cw.buf = append(cw.buf, data...)
+	if len(cw.buf) >= cw.options.MinSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// Flush 流式响应: 还没决定时按可压缩处理, 然后同时 flush 编码器和底层连接
+func (cw *compressWriter) Flush() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		_ = cw.decide(true)
+	}
+	if cw.encoder != nil {
+		_ = cw.encoder.Flush()
+	}
+	_ = http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap 支持 http.ResponseController 访问底层 ResponseWriter
+func (cw *compressWriter) Unwrap() http.ResponseWriter { return cw.ResponseWriter }
+
+// decide 确定是否压缩, 写出 header 和已缓冲的数据
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+	header := cw.Header()
+
+	if compress && header.Get("Content-Encoding") == "" && cw.compressible(header.Get("Content-Type")) {
+		encoder := cw.pool.Get().(compressor)
+		encoder.Reset(cw.ResponseWriter)
+		cw.encoder = encoder
+
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		// 强 ETag 对应的是未压缩内容, 压缩后降级为弱 ETag
+		if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			header.Set("ETag", "W/"+etag)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (cw *compressWriter) compressible(contentType string) bool {
+	if contentType == "" {
+		// 压缩后 net/http 无法再嗅探, 按未压缩内容提前补上
+		contentType = http.DetectContentType(cw.buf)
+		cw.Header().Set("Content-Type", contentType)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, skip := range cw.options.SkipTypes {
+		if strings.HasSuffix(skip, "/") {
+			if strings.HasPrefix(mediaType, skip) && mediaType != "image/svg+xml" {
+				return false
+			}
+		} else if mediaType == skip {
+			return false
+		}
+	}
+	return true
+}
+
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if cw.status == 0 {
+			// handler 没有写任何内容
+			return
+		}
+		// 总长度不足 MinSize
+		_ = cw.decide(false)
+	}
+	if cw.encoder != nil {
+		_ = cw.encoder.Close()
+		cw.encoder.Reset(io.Discard)
+		cw.pool.Put(cw.encoder)
+		cw.encoder = nil
+	}
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                        "",
+		"gzip, deflate, br":       "gzip",
+		"deflate":                 "deflate",
+		"gzip;q=0.5, deflate":     "deflate",
+		"gzip;q=0, *":             "deflate",
+		"*;q=0":                   "",
+		"identity":                "",
+		"br, GZIP;q=0.8":          "gzip",
+		"deflate;q=0.9, gzip;q=1": "gzip",
+	}
+	for accept, want := range cases {
+		if got := negotiateEncoding(accept); got != want {
+			t.Errorf("%q: got %q want %q", accept, got, want)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat(`{"name":"sbp","value":123},`, 100)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, large)
+	})
+	mux.HandleFunc("/small", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "tiny")
+	})
+	mux.HandleFunc("/png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = io.WriteString(w, large)
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, "part1 ")
+		w.(http.Flusher).Flush()
+		_, _ = io.WriteString(w, "part2")
+	})
+	h := Compress()(mux)
+
+	do := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Accept-Encoding", accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	// gzip
+	w := do("/large", "gzip")
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("gzip headers: %v", w.Header())
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(gr); string(data) != large {
+		t.Fatal("gzip body mismatch")
+	}
+
+	// deflate (zlib)
+	w = do("/large", "deflate")
+	zr, err := zlib.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(zr); string(data) != large {
+		t.Fatal("deflate body mismatch")
+	}
+
+	// 小响应 / 已压缩类型 / 客户端不支持
+	for _, c := range [][2]string{{"/small", "gzip"}, {"/png", "gzip"}, {"/large", ""}} {
+		w = do(c[0], c[1])
+		if w.Header().Get("Content-Encoding") != "" {
+			t.Fatalf("%s should not be compressed", c[0])
+		}
+	}
+
+	// 流式 Flush
+	w = do("/stream", "gzip")
+	if w.Header().Get("Content-Encoding") != "gzip" || !w.Flushed {
+		t.Fatalf("stream headers: %v flushed %v", w.Header(), w.Flushed)
+	}
+	gr, _ = gzip.NewReader(bytes.NewReader(w.Body.Bytes()))
+	if data, _ := io.ReadAll(gr); string(data) != "part1 part2" {
+		t.Fatalf("stream body: %q", data)
+	}
+}
+
+func TestCompressInvalidLevel(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("invalid level accepted")
+		}
+	}()
+	Compress(CompressOptions{Level: 42})
+}
@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wangzhione/sbp/chain"
+)
+
+// CORSOptions 跨域配置
+type CORSOptions struct {
+	// AllowedOrigins 允许的 Origin, "*" 表示全部, 支持子域名通配 "https://*.example.com"
+	AllowedOrigins []string
+	// AllowedMethods 允许的 method, 为空默认 GET HEAD POST PUT PATCH DELETE
+	AllowedMethods []string
+	// AllowedHeaders 允许的请求 header, 为空默认 Content-Type Authorization X-Request-Id; "*" 表示回显预检请求的 header
+	AllowedHeaders []string
+	// ExposedHeaders 允许前端 js 读取的响应 header, 为空默认 X-Request-Id
+	ExposedHeaders []string
+	// AllowCredentials 是否允许携带 cookie, 回显具体 Origin; 不能和 AllowedOrigins "*" 同时使用, 否则任意网站都能带 cookie 跨域调用
+	AllowCredentials bool
+	// MaxAge 预检结果缓存时间, 0 不设置交给浏览器默认 (Chrome 5s)
+	MaxAge time.Duration
+}
+
+// CORS 跨域中间件, 预检请求 (OPTIONS + Access-Control-Request-Method) 直接返回 204 不进入业务 handler
+// AllowedOrigins 包含 "*" 且 AllowCredentials 为 true 时 panic, 配置错误在启动阶段暴露
+func CORS(options CORSOptions) Middleware {
+	if len(options.AllowedMethods) == 0 {
+		options.AllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+	if len(options.AllowedHeaders) == 0 {
+		options.AllowedHeaders = []string{"Content-Type", "Authorization", chain.XRquestID}
+	}
+	if len(options.ExposedHeaders) == 0 {
+		options.ExposedHeaders = []string{chain.XRquestID}
+	}
+
+	allowAllOrigins := false
+	for _, origin := range options.AllowedOrigins {
+		if origin == "*" {
+			allowAllOrigins = true
+		}
+	}
+	if allowAllOrigins && options.AllowCredentials {
+		panic(`middleware: CORS AllowedOrigins "*" cannot be used with AllowCredentials`)
+	}
+	allowAllHeaders := len(options.AllowedHeaders) == 1 && options.AllowedHeaders[0] == "*"
+
+	allowMethods := strings.Join(options.AllowedMethods, ", ")
+	allowHeaders := strings.Join(options.AllowedHeaders, ", ")
+	exposeHeaders := strings.Join(options.ExposedHeaders, ", ")
+	maxAge := ""
+	if options.MaxAge > 0 {
+		maxAge = strconv.FormatInt(int64(options.MaxAge/time.Second), 10)
+	}
+
+	originAllowed := func(origin string) bool {
+		if allowAllOrigins {
+			return true
+		}
+		for _, allowed := range options.AllowedOrigins {
+			if matchOrigin(allowed, origin) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			// 响应内容随 Origin 变化, 告诉 CDN / 代理缓存分开存
+			header.Add("Vary", "Origin")
+			if preflight {
+				header.Add("Vary", "Access-Control-Request-Method")
+				header.Add("Vary", "Access-Control-Request-Headers")
+			}
+
+			if origin == "" {
+				// 非跨域请求
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !originAllowed(origin) {
+				if preflight {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				// 简单请求不带 CORS header, 由浏览器拦截
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if allowAllOrigins {
+				header.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				header.Set("Access-Control-Allow-Origin", origin)
+			}
+			if options.AllowCredentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			if !preflight {
+				header.Set("Access-Control-Expose-Headers", exposeHeaders)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			method := r.Header.Get("Access-Control-Request-Method")
+			if !containsFold(options.AllowedMethods, method) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			requestHeaders := r.Header.Get("Access-Control-Request-Headers")
+			if allowAllHeaders {
+				if requestHeaders != "" {
+					header.Set("Access-Control-Allow-Headers", requestHeaders)
+				}
+			} else {
+				for name := range strings.SplitSeq(requestHeaders, ",") {
+					if name = strings.TrimSpace(name); name != "" && !containsFold(options.AllowedHeaders, name) {
+						w.WriteHeader(http.StatusForbidden)
+						return
+					}
+				}
+				header.Set("Access-Control-Allow-Headers", allowHeaders)
+			}
+
+			header.Set("Access-Control-Allow-Methods", allowMethods)
+			if maxAge != "" {
+				header.Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// matchOrigin allowed 支持 "https://*.example.com" 通配任意一级或多级子域名, 不匹配 https://example.com 本身
+func matchOrigin(allowed, origin string) bool {
+	if strings.EqualFold(allowed, origin) {
+		return true
+	}
+
+	prefix, suffix, found := strings.Cut(allowed, "*")
+	if !found {
+		return false
+	}
+	return len(origin) > len(prefix)+len(suffix) &&
+		strings.HasPrefix(strings.ToLower(origin), strings.ToLower(prefix)) &&
+		strings.HasSuffix(strings.ToLower(origin), strings.ToLower(suffix))
+}
+
+func containsFold(values []string, s string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/wangzhione/sbp/chain"
+	"github.com/wangzhione/sbp/https/response"
+)
+
+// errBudgetExhausted 调用方已经放弃, 属于预期行为, 按 Warn 记录
+var errBudgetExhausted = func() *response.Error {
+	e := response.ErrGatewayTimeout.WithMsg("deadline budget exhausted")
+	e.Level = slog.LevelWarn
+	return e
+}()
+
+// Deadline 读取调用方传来的 chain.XRequestTimeout 剩余预算, 减去 margin 后设置为请求 ctx deadline
+// margin <= 0 使用 chain.DeadlineMargin; 预算已经用完直接返回 504, 不再执行 handler
+// handler 内通过 httpip 继续调用下游时, 剩余预算会自动继续传递
+func Deadline(margin time.Duration) Middleware {
+	if margin <= 0 {
+		margin = chain.DeadlineMargin
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := chain.WithDeadlineHeader(r.Context(), r.Header, margin)
+			defer cancel()
+
+			if ctx.Err() != nil {
+				response.Fail(ctx, w, errBudgetExhausted.WithMsg("deadline budget exhausted, %sms left", r.Header.Get(chain.XRequestTimeout)))
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wangzhione/sbp/chain"
+)
+
+func TestDeadline(t *testing.T) {
+	var left time.Duration
+	handler := Deadline(100 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, ok := r.Context().Deadline()
+		if ok {
+			left = time.Until(deadline)
+		}
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(chain.XRequestTimeout, "1000")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || left <= 800*time.Millisecond || left > 900*time.Millisecond {
+		t.Fatalf("status %d left %v", w.Code, left)
+	}
+
+	r.Header.Set(chain.XRequestTimeout, "80")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("exhausted budget status %d", w.Code)
+	}
+}
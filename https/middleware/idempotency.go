@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/wangzhione/sbp/chain"
+	"github.com/wangzhione/sbp/helper/rediser"
+	"github.com/wangzhione/sbp/https/response"
+	"github.com/wangzhione/sbp/util/idhash"
+)
+
+// IdempotencyOptions 幂等配置
+type IdempotencyOptions struct {
+	R       *rediser.Client
+	Prefix  string        // redis key 前缀, 默认 idempotency:
+	Header  string        // 幂等 key header, 默认 Idempotency-Key
+	Methods []string      // 需要幂等的 method, 默认 POST PATCH
+	TTL     time.Duration // 响应保存时长, 默认 24h
+	LockTTL time.Duration // 执行中锁时长, 应大于 handler 最长耗时, 默认 30s
+	MaxBody int           // 响应体超过该值不保存, 默认 1MB
+}
+
+// idempotencyRecord 保存在 redis 中的响应
+type idempotencyRecord struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// Idempotency 基于 Idempotency-Key header 的幂等中间件
+// 同一个 key 第一次请求执行 handler 并保存响应, 之后的请求直接回放; 第一次还在执行时并发重复请求返回 409
+// 5xx 响应不保存, 客户端可以用同一个 key 重试
+func Idempotency(options IdempotencyOptions) Middleware {
+	if options.Prefix == "" {
+		options.Prefix = "idempotency:"
+	}
+	if options.Header == "" {
+		options.Header = "Idempotency-Key"
+	}
+	if len(options.Methods) == 0 {
+		options.Methods = []string{http.MethodPost, http.MethodPatch}
+	}
+	if options.TTL <= 0 {
+		options.TTL = 24 * time.Hour
+	}
+	if options.LockTTL <= 0 {
+		options.LockTTL = 30 * time.Second
+	}
+	if options.MaxBody <= 0 {
+		options.MaxBody = 1 << 20
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(options.Header)
+			if key == "" || !slices.Contains(options.Methods, r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := r.Context()
+			// 同一个 key 用在不同接口上互不影响
+			storeKey := options.Prefix + idhash.MD5(r.Method+" "+r.URL.Path+" "+key)
+			lockKey := storeKey + ":lock"
+
+			if replayIdempotency(w, r, options.R, storeKey) {
+				return
+			}
+
+			lock, err := options.R.TryLock(ctx, lockKey, options.LockTTL)
+			if err != nil {
+				response.Fail(ctx, w, response.ErrUnavailable.Wrap(err))
+				return
+			}
+			if lock == nil {
+				// 可能第一次请求刚好在 Get 与 TryLock 之间结束
+				if replayIdempotency(w, r, options.R, storeKey) {
+					return
+				}
+				response.Fail(ctx, w, response.ErrConflict.WithMsg("request with the same %s is in progress", options.Header))
+				return
+			}
+			// handler 执行完之后客户端可能已经断开, 保存响应和解锁不能跟随请求取消
+			// 否则重试请求会拿到 409 或者再次执行 handler
+			storectx := context.WithoutCancel(ctx)
+			defer func() {
+				if ok, err := lock.Unlock(storectx); err != nil || !ok {
+					slog.ErrorContext(storectx, "Idempotency Unlock error", "error", err, "key", key, "unlocked", ok)
+				}
+			}()
+
+			// 拿到锁之后再确认一次, 避免上一个持锁者刚保存完
+			if replayIdempotency(w, r, options.R, storeKey) {
+				return
+			}
+
+			rec := &recordWriter{ResponseWriter: w, max: options.MaxBody}
+			next.ServeHTTP(rec, r)
+
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			if rec.status >= http.StatusInternalServerError || rec.overflow {
+				slog.InfoContext(ctx, "Idempotency response not stored", "key", key, "status", rec.status, "overflow", rec.overflow)
+				return
+			}
+
+			data, err := json.Marshal(idempotencyRecord{Status: rec.status, Header: rec.header, Body: rec.body})
+			if err != nil {
+				slog.ErrorContext(ctx, "Idempotency json.Marshal error", "error", err, "key", key)
+				return
+			}
+			if err = options.R.Set(storectx, storeKey, data, options.TTL); err != nil {
+				slog.ErrorContext(storectx, "Idempotency store response error", "error", err, "key", key)
+			}
+		})
+	}
+}
+
+// replayIdempotency 找到已保存的响应时回放并返回 true
+func replayIdempotency(w http.ResponseWriter, r *http.Request, rdb *rediser.Client, storeKey string) bool {
+	ctx := r.Context()
+	value, ok, err := rdb.Get(ctx, storeKey)
+	if err != nil || !ok {
+		return false
+	}
+
+	var record idempotencyRecord
+	if err = json.Unmarshal([]byte(value), &record); err != nil {
+		slog.ErrorContext(ctx, "Idempotency json.Unmarshal error", "error", err, "storeKey", storeKey)
+		return false
+	}
+
+	header := w.Header()
+	for key, values := range record.Header {
+		header[key] = values
+	}
+	header.Set("Idempotent-Replayed", "true")
+	w.WriteHeader(record.Status)
+	_, _ = w.Write(record.Body)
+
+	slog.InfoContext(ctx, "Idempotency replay", "storeKey", storeKey, "status", record.Status, "path", r.URL.Path)
+	return true
+}
+
+// recordWriter 透传响应的同时记录状态码, header 和 body
+type recordWriter struct {
+	http.ResponseWriter
+	max int
+
+	status   int
+	header   http.Header
+	body     []byte
+	overflow bool
+}
+
+func (rec *recordWriter) WriteHeader(status int) {
+	if rec.status == 0 && status >= http.StatusOK {
+		rec.status = status
+		rec.header = rec.Header().Clone()
+		// 回放时 trace id 属于新请求, 不复用第一次的
+		rec.header.Del(chain.XRquestID)
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *recordWriter) Write(data []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.overflow {
+		if len(rec.body)+len(data) > rec.max {
+			rec.overflow = true
+			rec.body = nil
+		} else {
+			rec.body = append(rec.body, data...)
+		}
+	}
+	return rec.ResponseWriter.Write(data)
+}
+
+// Unwrap 支持 http.ResponseController
+func (rec *recordWriter) Unwrap() http.ResponseWriter { return rec.ResponseWriter }
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wangzhione/sbp/helper/rediser"
+	"github.com/wangzhione/sbp/helper/rediser/redistest"
+	"github.com/wangzhione/sbp/system"
+)
+
+func TestIdempotency(t *testing.T) {
+	r := redistest.Require(t, rediser.NewDefaultRedis)
+
+	var calls atomic.Int64
+	release := make(chan struct{})
+	h := Idempotency(IdempotencyOptions{R: r, TTL: time.Minute})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		w.Header().Set("X-Order-Id", fmt.Sprint(n))
+		w.WriteHeader(http.StatusCreated)
+		_, _ = fmt.Fprintf(w, "order %d", n)
+	}))
+
+	key := system.UUID()
+	do := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequestWithContext(context.Background(), http.MethodPost, path, nil)
+		req.Header.Set("Idempotency-Key", key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	first := do("/order")
+	second := do("/order")
+	if calls.Load() != 1 {
+		t.Fatalf("handler called %d times", calls.Load())
+	}
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() ||
+		second.Header().Get("X-Order-Id") != "1" || second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("replay mismatch: %d %q %v", second.Code, second.Body.String(), second.Header())
+	}
+
+	// 第一次还在执行时的并发重复请求
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- do("/slow") }()
+	time.Sleep(100 * time.Millisecond)
+	if conflict := do("/slow"); conflict.Code != http.StatusConflict {
+		t.Fatalf("concurrent duplicate: %d", conflict.Code)
+	}
+	close(release)
+	if w := <-done; w.Code != http.StatusCreated {
+		t.Fatalf("slow first: %d", w.Code)
+	}
+}
@@ -0,0 +1,241 @@
+package middleware
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wangzhione/sbp/https/response"
+)
+
+// Priority 请求优先级, 过载时低优先级先被丢弃
+type Priority int
+
+const (
+	PriorityNormal   Priority = iota // 达到并发上限后丢弃
+	PriorityLow                      // 达到并发上限的 LowRatio 后就开始丢弃, 例如批量任务, 报表导出
+	PriorityCritical                 // 永不丢弃, 例如健康检查, 管理接口
+)
+
+// DefaultCriticalPaths 默认永不丢弃的路径前缀
+var DefaultCriticalPaths = []string{"/health", "/ready", "/livez", "/metrics"}
+
+// AdaptiveLimitOptions 自适应并发限制配置
+type AdaptiveLimitOptions struct {
+	InitialLimit int // 初始并发上限, 默认 20
+	MinLimit     int // 默认 1
+	MaxLimit     int // 默认 1000
+
+	// Tolerance 短期平均耗时超过长期平均耗时的倍数视为过载, 触发乘性减少, 默认 2
+	Tolerance float64
+	// Backoff 过载时 limit 乘以该系数, 默认 0.9
+	Backoff float64
+	// LowRatio PriorityLow 请求可用的并发比例, 默认 0.8
+	LowRatio float64
+
+	RetryAfter time.Duration // 丢弃时 Retry-After, 默认 1s
+
+	// CriticalPaths 路径前缀按路径段命中即为 PriorityCritical (/health 命中 /health/db, 不命中 /healthcheck), 默认 DefaultCriticalPaths
+	CriticalPaths []string
+	// Priority 自定义优先级, 返回 PriorityNormal 时继续按 CriticalPaths 判断
+	Priority func(r *http.Request) Priority
+}
+
+// AdaptiveLimiter AIMD 自适应并发限制器
+// 短期平均耗时接近长期平均时 limit 加性增加 (每一轮约 +1), 耗时明显变长或 5xx 时乘性减少 (每个 RTT 窗口最多一次), 在途请求超过 limit 直接丢弃而不是排队
+type AdaptiveLimiter struct {
+	options AdaptiveLimitOptions
+
+	mu           sync.Mutex
+	limit        float64
+	inflight     int       // 参与限流的在途请求, 不含 critical
+	critical     int       // critical 在途请求, 不占用 limit
+	rtt          float64   // 长期平均耗时 EWMA, 纳秒, 慢速跟随, 作为基线
+	rttShort     float64   // 短期平均耗时 EWMA, 纳秒, 快速反映当前负载
+	lastDecrease time.Time // 上次乘性减少时间
+}
+
+// NewAdaptiveLimiter 创建自适应并发限制器, 同一个实例可以挂在多个路由上共享容量
+func NewAdaptiveLimiter(options AdaptiveLimitOptions) *AdaptiveLimiter {
+	if options.MinLimit <= 0 {
+		options.MinLimit = 1
+	}
+	if options.MaxLimit <= 0 {
+		options.MaxLimit = 1000
+	}
+	if options.InitialLimit <= 0 {
+		options.InitialLimit = 20
+	}
+	options.InitialLimit = min(max(options.InitialLimit, options.MinLimit), options.MaxLimit)
+	if options.Tolerance <= 1 {
+		options.Tolerance = 2
+	}
+	if options.Backoff <= 0 || options.Backoff >= 1 {
+		options.Backoff = 0.9
+	}
+	if options.LowRatio <= 0 || options.LowRatio > 1 {
+		options.LowRatio = 0.8
+	}
+	if options.RetryAfter <= 0 {
+		options.RetryAfter = time.Second
+	}
+	if options.CriticalPaths == nil {
+		options.CriticalPaths = DefaultCriticalPaths
+	}
+
+	return &AdaptiveLimiter{options: options, limit: float64(options.InitialLimit)}
+}
+
+// Limit 当前并发上限
+func (l *AdaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// InFlight 当前参与限流的在途请求数, 不含 critical
+func (l *AdaptiveLimiter) InFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inflight
+}
+
+// CriticalInFlight 当前 critical 在途请求数
+func (l *AdaptiveLimiter) CriticalInFlight() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.critical
+}
+
+func (l *AdaptiveLimiter) priority(r *http.Request) Priority {
+	if l.options.Priority != nil {
+		if priority := l.options.Priority(r); priority != PriorityNormal {
+			return priority
+		}
+	}
+	for _, prefix := range l.options.CriticalPaths {
+		if matchPathPrefix(r.URL.Path, prefix) {
+			return PriorityCritical
+		}
+	}
+	return PriorityNormal
+}
+
+// matchPathPrefix 按路径段匹配前缀, /health 匹配 /health 和 /health/db, 不匹配 /healthcheck
+func matchPathPrefix(path, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+// acquire 返回 false 表示需要丢弃
+func (l *AdaptiveLimiter) acquire(priority Priority) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	switch priority {
+	case PriorityCritical:
+		// critical 永不丢弃, 单独计数, 避免占满 inflight 挤掉普通请求
+		l.critical++
+		return true
+	case PriorityLow:
+		if float64(l.inflight) >= math.Max(1, l.limit*l.options.LowRatio) {
+			return false
+		}
+	default:
+		if float64(l.inflight) >= l.limit {
+			return false
+		}
+	}
+	l.inflight++
+	return true
+}
+
+// release 根据本次耗时和结果调整 limit; critical 请求不参与 limit 调整, 健康检查很快, 会拉低基线
+func (l *AdaptiveLimiter) release(priority Priority, elapsed time.Duration, failed bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if priority == PriorityCritical {
+		l.critical--
+		return
+	}
+
+	l.inflight--
+	sample := float64(elapsed)
+	if !failed {
+		// 成功样本都计入两个 EWMA, 基线可以随延迟整体上升或下降, 不会被早期一次很快的请求卡住
+		if l.rtt == 0 {
+			l.rtt, l.rttShort = sample, sample
+		} else {
+			l.rtt = l.rtt*0.98 + sample*0.02
+			l.rttShort = l.rttShort*0.8 + sample*0.2
+		}
+	}
+
+	if failed || l.rttShort > l.rtt*l.options.Tolerance {
+		// 同一 RTT 窗口内的过载样本来自同一次拥塞, 只减少一次, 否则并发越高 limit 掉得越狠
+		now := time.Now()
+		if now.Sub(l.lastDecrease) >= time.Duration(l.rtt) {
+			l.lastDecrease = now
+			l.limit = math.Max(float64(l.options.MinLimit), l.limit*l.options.Backoff)
+		}
+	} else if float64(l.inflight) >= l.limit/2 {
+		// 只有容量真正被用到一半以上才增长, 避免空闲时 limit 无限膨胀
+		l.limit = math.Min(float64(l.options.MaxLimit), l.limit+1/l.limit)
+	}
+}
+
+// errOverloaded 丢弃是预期行为, 按 Warn 记录, 不触发错误告警
+var errOverloaded = func() *response.Error {
+	e := response.ErrUnavailable.WithMsg("server is overloaded, retry later")
+	e.Level = slog.LevelWarn
+	return e
+}()
+
+// LoadShed 自适应并发限制中间件, 超过并发上限返回 503 和 Retry-After
+//
+//	limiter := middleware.NewAdaptiveLimiter(middleware.AdaptiveLimitOptions{})
+//	handler = middleware.Chain(mux, middleware.LoadShed(limiter))
+func LoadShed(limiter *AdaptiveLimiter) Middleware {
+	retryAfter := strconv.Itoa(max(1, int(math.Ceil(limiter.options.RetryAfter.Seconds()))))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			priority := limiter.priority(r)
+			if !limiter.acquire(priority) {
+				w.Header().Set("Retry-After", retryAfter)
+				response.Fail(r.Context(), w, errOverloaded.WithMsg("server is overloaded, limit %d", limiter.Limit()))
+				return
+			}
+
+			sw := &statusWriter{ResponseWriter: w}
+			begin := time.Now()
+			defer func() {
+				limiter.release(priority, time.Since(begin), sw.status >= http.StatusInternalServerError)
+			}()
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
+
+// statusWriter 记录响应状态码
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if sw.status == 0 {
+		sw.status = status
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap 支持 http.ResponseController
+func (sw *statusWriter) Unwrap() http.ResponseWriter { return sw.ResponseWriter }
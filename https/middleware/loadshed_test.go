@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadShed(t *testing.T) {
+	limiter := NewAdaptiveLimiter(AdaptiveLimitOptions{
+		InitialLimit: 2,
+		Priority: func(r *http.Request) Priority {
+			switch r.URL.Path {
+			case "/healthz":
+				return PriorityCritical
+			case "/export":
+				return PriorityLow
+			}
+			return PriorityNormal
+		},
+	})
+
+	block := make(chan struct{})
+	started := make(chan struct{}, 2)
+	handler := LoadShed(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			started <- struct{}{}
+			<-block
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Go(func() { serve("/slow") })
+	}
+	<-started
+	<-started
+	if limiter.InFlight() != 2 {
+		t.Fatalf("inflight = %d", limiter.InFlight())
+	}
+
+	w := serve("/api")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("normal request status %d Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w = serve("/export"); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("low priority request status %d", w.Code)
+	}
+	if w = serve("/healthz"); w.Code != http.StatusOK {
+		t.Fatalf("critical request status %d", w.Code)
+	}
+
+	close(block)
+	wg.Wait()
+	if limiter.InFlight() != 0 {
+		t.Fatalf("inflight = %d", limiter.InFlight())
+	}
+	if w = serve("/api"); w.Code != http.StatusOK {
+		t.Fatalf("status after drain %d", w.Code)
+	}
+}
+
+func TestAdaptiveLimiter(t *testing.T) {
+	limiter := NewAdaptiveLimiter(AdaptiveLimitOptions{InitialLimit: 10, MinLimit: 2})
+
+	// 耗时稳定且容量被用满时加性增长
+	for range 100 {
+		for range 10 {
+			limiter.acquire(PriorityNormal)
+		}
+		for range 10 {
+			limiter.release(PriorityNormal, 10*time.Millisecond, false)
+		}
+	}
+	grown := limiter.Limit()
+	if grown <= 10 {
+		t.Fatalf("limit should grow, got %d", grown)
+	}
+
+	// 同一 RTT 窗口内多个过载样本只减少一次
+	for range 10 {
+		limiter.acquire(PriorityNormal)
+		limiter.release(PriorityNormal, time.Second, false)
+	}
+	if limit := limiter.Limit(); limit != int(float64(grown)*0.9) && limit != int(float64(grown)*0.9)+1 {
+		t.Fatalf("limit should shrink once, %d -> %d", grown, limit)
+	}
+
+	// 耗时明显变长时每个窗口乘性减少, 但不低于 MinLimit
+	for range 100 {
+		limiter.lastDecrease = time.Time{}
+		limiter.acquire(PriorityNormal)
+		limiter.release(PriorityNormal, time.Second, false)
+	}
+	if limit := limiter.Limit(); limit != 2 {
+		t.Fatalf("limit should shrink to MinLimit, got %d", limit)
+	}
+
+	// critical 不占用 inflight, 满载时照常放行
+	for range 2 {
+		limiter.acquire(PriorityNormal)
+	}
+	if !limiter.acquire(PriorityCritical) || limiter.InFlight() != 2 || limiter.CriticalInFlight() != 1 {
+		t.Fatalf("inflight %d critical %d", limiter.InFlight(), limiter.CriticalInFlight())
+	}
+	limiter.release(PriorityCritical, time.Millisecond, false)
+	if limiter.InFlight() != 2 || limiter.CriticalInFlight() != 0 {
+		t.Fatalf("inflight %d critical %d", limiter.InFlight(), limiter.CriticalInFlight())
+	}
+}
+
+func TestAdaptiveLimiterBaseline(t *testing.T) {
+	limiter := NewAdaptiveLimiter(AdaptiveLimitOptions{InitialLimit: 50})
+
+	// 一次很快的请求之后延迟整体上升, 基线需要跟上, 不能一直判定为过载
+	limiter.acquire(PriorityNormal)
+	limiter.release(PriorityNormal, time.Millisecond, false)
+	for range 500 {
+		limiter.acquire(PriorityNormal)
+		limiter.release(PriorityNormal, 20*time.Millisecond, false)
+	}
+	if limiter.rtt < float64(15*time.Millisecond) || limiter.Limit() < 40 {
+		t.Fatalf("rtt %v limit %d", time.Duration(limiter.rtt), limiter.Limit())
+	}
+}
+
+func TestLimiterPriority(t *testing.T) {
+	limiter := NewAdaptiveLimiter(AdaptiveLimitOptions{Priority: func(r *http.Request) Priority {
+		if r.URL.Path == "/export" {
+			return PriorityLow
+		}
+		return PriorityNormal
+	}})
+
+	for path, want := range map[string]Priority{
+		"/health":            PriorityCritical,
+		"/health/db":         PriorityCritical,
+		"/healthcheck-admin": PriorityNormal,
+		"/metrics":           PriorityCritical,
+		"/export":            PriorityLow,
+		"/api":               PriorityNormal,
+	} {
+		if got := limiter.priority(httptest.NewRequest(http.MethodGet, path, nil)); got != want {
+			t.Errorf("priority(%s) = %d, want %d", path, got, want)
+		}
+	}
+}
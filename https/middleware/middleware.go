@@ -0,0 +1,19 @@
+// Package middleware provides net/http middleware for services started by https.ServeLoop.
+package middleware
+
+import (
+	"net/http"
+)
+
+// Middleware 标准 net/http 中间件
+type Middleware func(http.Handler) http.Handler
+
+// Chain 按顺序套用中间件, Chain(h, a, b) 请求经过顺序 a -> b -> h
+//
+//	https.ServeLoop(ctx, addr, middleware.Chain(mux, middleware.RateLimit(policy)), stopTime)
+func Chain(h http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
@@ -0,0 +1,244 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wangzhione/sbp/helper/rediser"
+	"github.com/wangzhione/sbp/https/httpip"
+	"github.com/wangzhione/sbp/https/response"
+)
+
+// KeyFunc 从请求中提取限流 key, 返回空串表示该请求不限流
+type KeyFunc func(r *http.Request) string
+
+// KeyByIP 按客户端 ip 限流
+func KeyByIP(r *http.Request) string {
+	return "ip:" + httpip.GetClientIP(r)
+}
+
+// KeyByRoute 按 method + path 限流, 所有客户端共享同一个配额
+func KeyByRoute(r *http.Request) string {
+	return "route:" + r.Method + " " + r.URL.Path
+}
+
+// KeyByHeader 按指定 header 限流, 例如 X-User-Id; header 缺失时回退到客户端 ip
+func KeyByHeader(header string) KeyFunc {
+	return func(r *http.Request) string {
+		if value := r.Header.Get(header); value != "" {
+			return header + ":" + value
+		}
+		return KeyByIP(r)
+	}
+}
+
+// LimitResult 一次限流判定结果
+type LimitResult struct {
+	Allowed   bool
+	Limit     int64         // 窗口内最大请求次数
+	Remaining int64         // 窗口内剩余次数
+	Reset     time.Duration // 距离窗口重置的时间
+}
+
+// RateLimiter 限流器, 本地 LocalLimiter 和 Redis RedisLimiter 二选一
+type RateLimiter interface {
+	Take(ctx context.Context, key string) (LimitResult, error)
+}
+
+// LocalLimiter 进程内固定窗口限流, 适合单实例或对精度要求不高的场景
+type LocalLimiter struct {
+	TTL time.Duration // 窗口时长
+	N   int64         // 窗口内最大请求次数
+
+	mu        sync.Mutex
+	windows   map[string]*localWindow
+	lastSweep time.Time
+}
+
+type localWindow struct {
+	count int64
+	reset time.Time
+}
+
+// NewLocalLimiter 创建进程内限流器, ttl 时间内最多允许 limit 次请求
+func NewLocalLimiter(ttl time.Duration, limit int64) *LocalLimiter {
+	return &LocalLimiter{
+		TTL:     ttl,
+		N:       limit,
+		windows: make(map[string]*localWindow),
+	}
+}
+
+// Take implements RateLimiter
+func (l *LocalLimiter) Take(ctx context.Context, key string) (result LimitResult, err error) {
+	now := time.Now()
+
+	l.mu.Lock()
+	// 每个窗口周期清理一次过期 key, 防止 ip 之类的 key 无限增长
+	if now.Sub(l.lastSweep) >= l.TTL {
+		for k, w := range l.windows {
+			if !now.Before(w.reset) {
+				delete(l.windows, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	w, ok := l.windows[key]
+	if !ok || !now.Before(w.reset) {
+		w = &localWindow{reset: now.Add(l.TTL)}
+		l.windows[key] = w
+	}
+	w.count++
+	count, reset := w.count, w.reset
+	l.mu.Unlock()
+
+	return newLimitResult(l.N, count, reset.Sub(now)), nil
+}
+
+// RedisLimiter 基于 rediser.Limiter 的分布式固定窗口限流, 多实例共享配额
+type RedisLimiter struct {
+	R      *rediser.Client
+	Prefix string        // redis key 前缀, 推荐 ratelimit:{service}:
+	TTL    time.Duration // 窗口时长
+	N      int64         // 窗口内最大请求次数
+}
+
+// NewRedisLimiter 创建 Redis 限流器, ttl 时间内最多允许 limit 次请求
+func NewRedisLimiter(r *rediser.Client, prefix string, ttl time.Duration, limit int64) *RedisLimiter {
+	return &RedisLimiter{
+		R:      r,
+		Prefix: prefix,
+		TTL:    ttl,
+		N:      limit,
+	}
+}
+
+// Take implements RateLimiter
+func (l *RedisLimiter) Take(ctx context.Context, key string) (result LimitResult, err error) {
+	count, reset, err := rediser.NewLimiter(l.R, l.Prefix+key, l.TTL, l.N).Take(ctx)
+	if err != nil {
+		return
+	}
+	return newLimitResult(l.N, count, reset), nil
+}
+
+func newLimitResult(limit, count int64, reset time.Duration) LimitResult {
+	return LimitResult{
+		Allowed:   count <= limit,
+		Limit:     limit,
+		Remaining: max(limit-count, 0),
+		Reset:     max(reset, 0),
+	}
+}
+
+// RateLimitPolicy 限流策略
+type RateLimitPolicy struct {
+	Limiter RateLimiter
+	Key     KeyFunc // 默认 KeyByIP
+
+	// FailClosed 限流器出错 (例如 Redis 不可用) 时是否拒绝请求, 默认放行, 不因限流组件故障拖垮业务
+	FailClosed bool
+}
+
+// RateLimit 限流中间件, 超限返回 429 并设置 Retry-After 和 X-RateLimit-* header
+func RateLimit(policy *RateLimitPolicy) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if policy.allow(w, r) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// RateLimitRoutes 按路由选择限流策略, routes key 格式同 http.ServeMux: "/api/" 前缀匹配, "/login" 精确匹配,
+// 也可以带 method 前缀 "POST /login"; 多个匹配时最长的生效, 都不匹配时使用 fallback, fallback 为 nil 则不限流
+func RateLimitRoutes(routes map[string]*RateLimitPolicy, fallback *RateLimitPolicy) Middleware {
+	type route struct {
+		method string
+		path   string
+		policy *RateLimitPolicy
+	}
+
+	sorted := make([]route, 0, len(routes))
+	for pattern, policy := range routes {
+		rt := route{path: pattern, policy: policy}
+		if method, path, found := strings.Cut(pattern, " "); found {
+			rt.method, rt.path = method, strings.TrimSpace(path)
+		}
+		sorted = append(sorted, rt)
+	}
+	// 最长 path 优先, 同 path 带 method 的优先
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i].path) != len(sorted[j].path) {
+			return len(sorted[i].path) > len(sorted[j].path)
+		}
+		return sorted[i].method > sorted[j].method
+	})
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			policy := fallback
+			for _, rt := range sorted {
+				if rt.method != "" && rt.method != r.Method {
+					continue
+				}
+				if r.URL.Path == rt.path || (strings.HasSuffix(rt.path, "/") && strings.HasPrefix(r.URL.Path, rt.path)) {
+					policy = rt.policy
+					break
+				}
+			}
+
+			if policy == nil || policy.allow(w, r) {
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
+
+// allow 返回 false 时已经写出 429 响应
+func (policy *RateLimitPolicy) allow(w http.ResponseWriter, r *http.Request) bool {
+	keyfn := policy.Key
+	if keyfn == nil {
+		keyfn = KeyByIP
+	}
+	key := keyfn(r)
+	if key == "" {
+		return true
+	}
+
+	ctx := r.Context()
+	result, err := policy.Limiter.Take(ctx, key)
+	if err != nil {
+		if policy.FailClosed {
+			response.Fail(ctx, w, response.ErrUnavailable.Wrap(err))
+			return false
+		}
+		slog.ErrorContext(ctx, "RateLimit Limiter.Take error, fail open", "error", err, "key", key)
+		return true
+	}
+
+	// 向上取整到秒, 避免 Reset 不足 1s 时输出 0
+	reset := strconv.FormatInt(int64((result.Reset+time.Second-1)/time.Second), 10)
+
+	header := w.Header()
+	header.Set("X-RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+	header.Set("X-RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+	header.Set("X-RateLimit-Reset", reset)
+	if result.Allowed {
+		return true
+	}
+
+	header.Set("Retry-After", reset)
+	// key 可能是用户 ID / IP / token, 只记录日志, 不返回给客户端
+	slog.WarnContext(ctx, "RateLimit exceeded", "key", key, "limit", result.Limit, "reset", reset)
+	response.Fail(ctx, w, response.ErrTooManyRequests.WithMsg("rate limit exceeded"))
+	return false
+}
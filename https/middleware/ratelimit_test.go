@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	_, _ = w.Write([]byte("ok"))
+})
+
+func TestRateLimit(t *testing.T) {
+	h := RateLimit(&RateLimitPolicy{
+		Limiter: NewLocalLimiter(time.Minute, 2),
+	})(okHandler)
+
+	codes := make([]int, 0, 3)
+	for range 3 {
+		req := httptest.NewRequest(http.MethodGet, "/api/order", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		codes = append(codes, w.Code)
+
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+			t.Fatal("429 without Retry-After")
+		}
+		if w.Code == http.StatusTooManyRequests && strings.Contains(w.Body.String(), "10.0.0.1") {
+			t.Fatalf("429 body leaks key: %s", w.Body.String())
+		}
+		t.Log(w.Code, w.Header().Get("X-RateLimit-Remaining"), w.Header().Get("Retry-After"))
+	}
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Fatalf("unexpected codes: %v", codes)
+	}
+
+	// 不同 ip 独立计数
+	req := httptest.NewRequest(http.MethodGet, "/api/order", nil)
+	req.RemoteAddr = "10.0.0.2:1234"
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("other ip should pass, got %d", w.Code)
+	}
+}
+
+func TestRateLimitRoutes(t *testing.T) {
+	h := RateLimitRoutes(map[string]*RateLimitPolicy{
+		"POST /login": {Limiter: NewLocalLimiter(time.Minute, 1), Key: KeyByRoute},
+		"/api/":       {Limiter: NewLocalLimiter(time.Minute, 100)},
+	}, nil)(okHandler)
+
+	do := func(method, path string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w.Code
+	}
+
+	if code := do(http.MethodPost, "/login"); code != http.StatusOK {
+		t.Fatalf("first login: %d", code)
+	}
+	if code := do(http.MethodPost, "/login"); code != http.StatusTooManyRequests {
+		t.Fatalf("second login: %d", code)
+	}
+	// GET /login 没有匹配任何策略, 不限流
+	if code := do(http.MethodGet, "/login"); code != http.StatusOK {
+		t.Fatalf("get login: %d", code)
+	}
+	if code := do(http.MethodGet, "/api/user"); code != http.StatusOK {
+		t.Fatalf("api: %d", code)
+	}
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SecureOptions 安全响应 header 配置, 字段为空表示不设置对应 header
+type SecureOptions struct {
+	HSTSMaxAge            time.Duration // Strict-Transport-Security max-age, 只在 https 请求上输出
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+
+	ContentTypeNosniff    bool   // X-Content-Type-Options: nosniff
+	FrameOptions          string // X-Frame-Options, DENY or SAMEORIGIN
+	ContentSecurityPolicy string // Content-Security-Policy
+	ReferrerPolicy        string // Referrer-Policy
+}
+
+// DefaultSecureOptions 默认配置偏 API 服务, 挂管理后台页面时按需放宽 ContentSecurityPolicy
+var DefaultSecureOptions = SecureOptions{
+	HSTSMaxAge:            180 * 24 * time.Hour,
+	HSTSIncludeSubdomains: true,
+	ContentTypeNosniff:    true,
+	FrameOptions:          "DENY",
+	ContentSecurityPolicy: "default-src 'self'; frame-ancestors 'none'",
+	ReferrerPolicy:        "strict-origin-when-cross-origin",
+}
+
+// Secure 安全 header 中间件, 不传参数使用 DefaultSecureOptions
+// header 在进入业务 handler 之前设置, 个别页面需要不同策略时 handler 内直接 Set 覆盖即可
+func Secure(opts ...SecureOptions) Middleware {
+	options := DefaultSecureOptions
+	if len(opts) > 0 {
+		options = opts[0]
+	}
+
+	hsts := ""
+	if options.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.FormatInt(int64(options.HSTSMaxAge/time.Second), 10)
+		if options.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if options.HSTSPreload {
+			hsts += "; preload"
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			setDefault := func(key, value string) {
+				if value != "" && header.Get(key) == "" {
+					header.Set(key, value)
+				}
+			}
+
+			// HSTS 只对 https 生效, TLS 在 LB 终结时依赖 X-Forwarded-Proto
+			if hsts != "" && (r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https") {
+				setDefault("Strict-Transport-Security", hsts)
+			}
+			if options.ContentTypeNosniff {
+				setDefault("X-Content-Type-Options", "nosniff")
+			}
+			setDefault("X-Frame-Options", options.FrameOptions)
+			setDefault("Content-Security-Policy", options.ContentSecurityPolicy)
+			setDefault("Referrer-Policy", options.ReferrerPolicy)
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package https
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/wangzhione/sbp/chain"
+	"github.com/wangzhione/sbp/https/httpip"
+	"github.com/wangzhione/sbp/https/response"
+)
+
+// 负载均衡策略
+const (
+	BalanceRoundRobin = "round_robin"
+	BalanceLeastConn  = "least_conn"
+)
+
+// ProxyOptions 反向代理配置
+type ProxyOptions struct {
+	Upstreams []string // 上游地址列表, 类似 "http://10.0.0.1:8080"
+	Balance   string   // BalanceRoundRobin (默认) or BalanceLeastConn
+
+	// MaxFails 连续失败 (连接错误或 502/503/504) 次数达到后摘除上游, 默认 3
+	MaxFails int64
+	// EjectTime 摘除时长, 到期后自动恢复, 默认 30s
+	EjectTime time.Duration
+
+	Transport     http.RoundTripper // 默认 httpip.HTTPTransport
+	FlushInterval time.Duration     // 同 httputil.ReverseProxy.FlushInterval, SSE 等流式响应会自动立即 flush
+}
+
+// upstream 上游状态
+type upstream struct {
+	target     *url.URL
+	active     atomic.Int64 // 进行中的请求数, least_conn 使用
+	fails      atomic.Int64 // 连续失败次数
+	ejectUntil atomic.Int64 // 摘除截止时间 unix nano
+}
+
+type proxyUpstreamKey struct{}
+
+// NewReverseProxy 基于 httputil.ReverseProxy 构建反向代理
+// 透传 trace id, 重写 X-Forwarded-* 和 RFC 7239 Forwarded, 在多个上游之间负载均衡, 失败上游被动摘除, 每个请求记录上游耗时
+func NewReverseProxy(options ProxyOptions) (*httputil.ReverseProxy, error) {
+	if len(options.Upstreams) == 0 {
+		return nil, errors.New("https: reverse proxy without upstreams")
+	}
+	if options.MaxFails <= 0 {
+		options.MaxFails = 3
+	}
+	if options.EjectTime <= 0 {
+		options.EjectTime = 30 * time.Second
+	}
+	if options.Transport == nil {
+		options.Transport = httpip.HTTPTransport
+	}
+
+	upstreams := make([]*upstream, 0, len(options.Upstreams))
+	for _, rawurl := range options.Upstreams {
+		target, err := url.Parse(rawurl)
+		if err != nil {
+			return nil, err
+		}
+		if target.Scheme == "" || target.Host == "" {
+			return nil, fmt.Errorf("https: invalid upstream %q", rawurl)
+		}
+		upstreams = append(upstreams, &upstream{target: target})
+	}
+
+	balancer := &proxyBalancer{upstreams: upstreams, leastConn: options.Balance == BalanceLeastConn}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			up := balancer.pick()
+			pr.SetURL(up.target)
+			pr.Out.Host = pr.In.Host // 保留原始 Host, 上游按虚拟主机路由时依赖它
+
+			// 只有直连方是可信代理时才保留它传来的转发链, 否则从我们这里重新开始
+			if trustForwarded(pr.In) {
+				// 复制一份, 否则后面 Header.Add 可能写进和 pr.In.Header 共用的底层数组
+				pr.Out.Header["X-Forwarded-For"] = slices.Clone(pr.In.Header["X-Forwarded-For"])
+				pr.Out.Header["Forwarded"] = slices.Clone(pr.In.Header["Forwarded"])
+			}
+			pr.SetXForwarded()
+			pr.Out.Header.Add("Forwarded", forwardedElement(pr.In))
+
+			// 优先复用上游中间件注入的 trace id, 其次入站 X-Request-Id, 都没有则生成
+			traceID := chain.GetTraceID(pr.In.Context())
+			if traceID == "" {
+				_, traceID = chain.Request(pr.In)
+			}
+			pr.Out.Header.Set(chain.XRquestID, traceID)
+			pr.Out = pr.Out.WithContext(context.WithValue(chain.WithContext(pr.Out.Context(), traceID), proxyUpstreamKey{}, up))
+		},
+		Transport:     &proxyTransport{next: options.Transport, maxFails: options.MaxFails, ejectTime: options.EjectTime},
+		FlushInterval: options.FlushInterval,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			response.Fail(r.Context(), w, response.ErrBadGateway.Wrap(err))
+		},
+	}
+	return proxy, nil
+}
+
+// trustForwarded 开启 httpip.SetTrustedProxies 后, 直连对端是可信代理时才信任入站转发 header
+func trustForwarded(r *http.Request) bool {
+	resolver := httpip.DefaultIPResolver()
+	if resolver == nil {
+		return false
+	}
+	remote, ok := httpip.ParseIP(r.RemoteAddr)
+	return ok && resolver.Trusted(remote)
+}
+
+// forwardedElement 生成本跳 RFC 7239 Forwarded element, IPv6 需要加引号和方括号
+func forwardedElement(r *http.Request) string {
+	node := "unknown"
+	if addr, ok := httpip.ParseIP(r.RemoteAddr); ok {
+		node = addr.String()
+		if addr.Is6() {
+			node = `"[` + node + `]"`
+		}
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	element := "for=" + node + ";proto=" + proto
+	if r.Host != "" {
+		element += `;host="` + strings.ReplaceAll(r.Host, `"`, "") + `"`
+	}
+	return element
+}
+
+type proxyBalancer struct {
+	upstreams []*upstream
+	leastConn bool
+	next      atomic.Uint64
+}
+
+// pick 跳过摘除中的上游, 全部被摘除时退化为全部可用, 避免整体不可用
+func (b *proxyBalancer) pick() *upstream {
+	now := time.Now().UnixNano()
+	start := b.next.Add(1)
+	n := uint64(len(b.upstreams))
+
+	var best *upstream
+	for i := range n {
+		up := b.upstreams[(start+i)%n]
+		if up.ejectUntil.Load() > now {
+			continue
+		}
+		if !b.leastConn {
+			return up
+		}
+		if best == nil || up.active.Load() < best.active.Load() {
+			best = up
+		}
+	}
+	if best == nil {
+		best = b.upstreams[start%n]
+	}
+	return best
+}
+
+// proxyTransport 统计上游并发和连续失败, 并记录每个代理请求
+type proxyTransport struct {
+	next      http.RoundTripper
+	maxFails  int64
+	ejectTime time.Duration
+}
+
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	up, _ := ctx.Value(proxyUpstreamKey{}).(*upstream)
+	if up == nil {
+		return t.next.RoundTrip(req)
+	}
+
+	begin := time.Now()
+	up.active.Add(1)
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(begin)
+
+	failed := err != nil
+	if resp != nil {
+		switch resp.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			failed = true
+		}
+	}
+	if failed && !errors.Is(err, context.Canceled) {
+		if fails := up.fails.Add(1); fails >= t.maxFails {
+			up.ejectUntil.Store(time.Now().Add(t.ejectTime).UnixNano())
+			slog.WarnContext(ctx, "ReverseProxy upstream ejected", "upstream", up.target.Host, "fails", fails, "ejectTime", t.ejectTime)
+		}
+	} else if !failed {
+		up.fails.Store(0)
+	}
+
+	if err != nil {
+		up.active.Add(-1)
+		slog.ErrorContext(ctx, "ReverseProxy upstream error", "error", err, "upstream", up.target.Host,
+			"method", req.Method, "path", req.URL.Path, "elapsed", elapsed.String())
+		return nil, err
+	}
+
+	// 响应体读完才算请求结束, least_conn 计数在 Close 时归还
+	resp.Body = &proxyBody{ReadCloser: resp.Body, up: up}
+	slog.InfoContext(ctx, "ReverseProxy request", "upstream", up.target.Host, "method", req.Method,
+		"path", req.URL.Path, "status", resp.StatusCode, "elapsed", elapsed.String())
+	return resp, nil
+}
+
+type proxyBody struct {
+	io.ReadCloser
+	up     *upstream
+	closed atomic.Bool
+}
+
+func (b *proxyBody) Close() error {
+	if b.closed.CompareAndSwap(false, true) {
+		b.up.active.Add(-1)
+	}
+	return b.ReadCloser.Close()
+}
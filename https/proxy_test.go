@@ -0,0 +1,140 @@
+package https
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wangzhione/sbp/chain"
+	"github.com/wangzhione/sbp/https/httpip"
+)
+
+func TestNewReverseProxy(t *testing.T) {
+	var hits [2]int
+	upstreams := make([]string, 2)
+	for i := range upstreams {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[i]++
+			w.Header().Set("X-Upstream-Trace", r.Header.Get(chain.XRquestID))
+			w.Header().Set("X-Upstream-XFF", r.Header.Get("X-Forwarded-For"))
+			w.Header().Set("X-Upstream-Forwarded", r.Header.Get("Forwarded"))
+			_, _ = io.WriteString(w, r.URL.Path)
+		}))
+		defer server.Close()
+		upstreams[i] = server.URL
+	}
+
+	proxy, err := NewReverseProxy(ProxyOptions{Upstreams: upstreams})
+	if err != nil {
+		t.Fatal(err)
+	}
+	front := httptest.NewServer(proxy)
+	defer front.Close()
+
+	for range 4 {
+		req, _ := http.NewRequest(http.MethodGet, front.URL+"/hello", nil)
+		req.Header.Set(chain.XRquestID, "trace-proxy")
+		// 客户端不可信, 伪造的转发链要被丢弃
+		req.Header.Set("X-Forwarded-For", "6.6.6.6")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if string(body) != "/hello" || resp.Header.Get("X-Upstream-Trace") != "trace-proxy" {
+			t.Fatalf("body %q trace %q", body, resp.Header.Get("X-Upstream-Trace"))
+		}
+		if xff := resp.Header.Get("X-Upstream-XFF"); xff != "127.0.0.1" {
+			t.Fatalf("X-Forwarded-For = %q", xff)
+		}
+		if forwarded := resp.Header.Get("X-Upstream-Forwarded"); !strings.HasPrefix(forwarded, "for=127.0.0.1;proto=http;host=") {
+			t.Fatalf("Forwarded = %q", forwarded)
+		}
+	}
+	if hits[0] != 2 || hits[1] != 2 {
+		t.Fatalf("round robin hits = %v", hits)
+	}
+}
+
+func TestNewReverseProxyTrustedForwarded(t *testing.T) {
+	httpip.SetTrustedProxies("192.0.2.0/24")
+	defer httpip.SetTrustedProxies()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["X-Upstream-Forwarded"] = r.Header["Forwarded"]
+	}))
+	defer server.Close()
+	proxy, err := NewReverseProxy(ProxyOptions{Upstreams: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 入站 header 切片有剩余容量, 追加本跳 Forwarded 不能写进入站请求的底层数组
+	backing := make([]string, 1, 4)
+	backing[0] = "for=1.1.1.1"
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header["Forwarded"] = backing
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if forwarded := w.Header()["X-Upstream-Forwarded"]; len(forwarded) != 2 || forwarded[0] != "for=1.1.1.1" {
+		t.Fatalf("Forwarded = %q", forwarded)
+	}
+	if leaked := backing[:2][1]; leaked != "" {
+		t.Fatalf("inbound header backing array modified: %q", leaked)
+	}
+}
+
+func TestNewReverseProxyEject(t *testing.T) {
+	var good, bad int
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		good++
+	}))
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bad++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	proxy, err := NewReverseProxy(ProxyOptions{
+		Upstreams: []string{healthy.URL, failing.URL},
+		Balance:   BalanceLeastConn,
+		MaxFails:  2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 10 {
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	if bad != 2 || good != 8 {
+		t.Fatalf("good %d bad %d, failing upstream should be ejected after 2 fails", good, bad)
+	}
+}
+
+func TestNewReverseProxyBadGateway(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	proxy, err := NewReverseProxy(ProxyOptions{Upstreams: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d", w.Code)
+	}
+
+	if _, err = NewReverseProxy(ProxyOptions{Upstreams: []string{"10.0.0.1:80"}}); err == nil {
+		t.Fatal("expected invalid upstream error")
+	}
+}
@@ -0,0 +1,103 @@
+package response
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// Error 业务错误码, 同时携带 HTTP 状态码, 默认提示信息和日志等级
+// 通过 Register 注册后全局唯一, 业务侧用 Wrap / WithMsg 派生出带上下文的副本, 不会修改注册值
+type Error struct {
+	Code   int        // 业务错误码, 对应响应体 code
+	Status int        // HTTP 状态码
+	Msg    string     // 返回给调用方的提示信息
+	Level  slog.Level // Fail 记录日志时的等级, 4xx 一般 Info/Warn, 5xx 一般 Error
+
+	cause error // 原始错误, 只进日志不进响应体
+}
+
+// Error implements error
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("code %d: %s: %v", e.Code, e.Msg, e.cause)
+	}
+	return fmt.Sprintf("code %d: %s", e.Code, e.Msg)
+}
+
+// Unwrap 支持 errors.Is / errors.As 穿透到原始错误
+func (e *Error) Unwrap() error { return e.cause }
+
+// Is 同一个业务错误码视为同一个错误, errors.Is(err, ErrNotFound) 不受 Wrap / WithMsg 影响
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Wrap 派生一个携带原始错误的副本, 原始错误只记录日志, 不暴露给调用方
+func (e *Error) Wrap(err error) *Error {
+	clone := *e
+	clone.cause = err
+	return &clone
+}
+
+// WithMsg 派生一个替换提示信息的副本
+func (e *Error) WithMsg(format string, args ...any) *Error {
+	clone := *e
+	if len(args) > 0 {
+		clone.Msg = fmt.Sprintf(format, args...)
+	} else {
+		clone.Msg = format
+	}
+	return &clone
+}
+
+var registry sync.Map // map[int]*Error
+
+// Register 注册业务错误码, 推荐在包级 var 中初始化; 重复注册同一个 code 直接 panic, 尽早暴露冲突
+// level 不传时 5xx 默认 slog.LevelError, 其他默认 slog.LevelWarn
+func Register(code, status int, msg string, level ...slog.Level) *Error {
+	e := &Error{
+		Code:   code,
+		Status: status,
+		Msg:    msg,
+		Level:  slog.LevelWarn,
+	}
+	if len(level) > 0 {
+		e.Level = level[0]
+	} else if status >= http.StatusInternalServerError {
+		e.Level = slog.LevelError
+	}
+
+	if _, loaded := registry.LoadOrStore(code, e); loaded {
+		panic(fmt.Sprintf("response: error code %d already registered", code))
+	}
+	return e
+}
+
+// Lookup 根据业务错误码查找注册信息
+func Lookup(code int) (e *Error, ok bool) {
+	value, ok := registry.Load(code)
+	if !ok {
+		return
+	}
+	return value.(*Error), true
+}
+
+// CodeOK 成功响应 code
+const CodeOK = 0
+
+// 通用错误码, 与 HTTP 状态码同值, 业务自定义错误码推荐从 10000 开始
+var (
+	ErrBadRequest      = Register(http.StatusBadRequest, http.StatusBadRequest, "bad request", slog.LevelInfo)
+	ErrUnauthorized    = Register(http.StatusUnauthorized, http.StatusUnauthorized, "unauthorized", slog.LevelInfo)
+	ErrForbidden       = Register(http.StatusForbidden, http.StatusForbidden, "forbidden", slog.LevelInfo)
+	ErrNotFound        = Register(http.StatusNotFound, http.StatusNotFound, "not found", slog.LevelInfo)
+	ErrConflict        = Register(http.StatusConflict, http.StatusConflict, "conflict")
+	ErrTooManyRequests = Register(http.StatusTooManyRequests, http.StatusTooManyRequests, "too many requests")
+	ErrInternal        = Register(http.StatusInternalServerError, http.StatusInternalServerError, "internal server error")
+	ErrBadGateway      = Register(http.StatusBadGateway, http.StatusBadGateway, "bad gateway")
+	ErrUnavailable     = Register(http.StatusServiceUnavailable, http.StatusServiceUnavailable, "service unavailable")
+	ErrGatewayTimeout  = Register(http.StatusGatewayTimeout, http.StatusGatewayTimeout, "gateway timeout")
+)
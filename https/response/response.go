@@ -0,0 +1,65 @@
+// Package response provides a standard JSON response envelope and a registry of business error codes.
+package response
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/wangzhione/sbp/chain"
+)
+
+// Body 统一响应结构 {code, msg, data, trace_id}
+type Body struct {
+	Code    int    `json:"code"`
+	Msg     string `json:"msg"`
+	Data    any    `json:"data,omitempty"`
+	TraceID string `json:"trace_id,omitempty"`
+}
+
+// OK 成功响应, HTTP 200 + code 0
+func OK(ctx context.Context, w http.ResponseWriter, data any) {
+	Write(ctx, w, http.StatusOK, Body{Code: CodeOK, Msg: "ok", Data: data})
+}
+
+// Fail 失败响应, err 链路上能找到 *Error 就按注册的错误码返回, 否则按 ErrInternal 兜底
+// 错误只在这里记录一次日志, 业务层返回 err 时不需要再重复打印
+func Fail(ctx context.Context, w http.ResponseWriter, err error) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = ErrInternal.Wrap(err)
+	}
+
+	attrs := []slog.Attr{
+		slog.Int("code", e.Code),
+		slog.Int("status", e.Status),
+		slog.String("msg", e.Msg),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	slog.LogAttrs(ctx, e.Level, "response Fail", attrs...)
+
+	Write(ctx, w, e.Status, Body{Code: e.Code, Msg: e.Msg})
+}
+
+// Write 写入 JSON 响应, 自动补充 trace id 到 body 和 X-Request-Id header
+func Write(ctx context.Context, w http.ResponseWriter, status int, body Body) {
+	if body.TraceID == "" {
+		body.TraceID = chain.GetTraceID(ctx)
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	if body.TraceID != "" {
+		header.Set(chain.XRquestID, body.TraceID)
+	}
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		// header 已经写出, 只能记录日志
+		slog.ErrorContext(ctx, "response json Encode error", "error", err, "status", status, "code", body.Code)
+	}
+}
@@ -0,0 +1,86 @@
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/wangzhione/sbp/chain"
+)
+
+var errOrderNotFound = Register(10404, http.StatusNotFound, "order not found")
+
+func TestOK(t *testing.T) {
+	ctx := chain.WithContext(chain.BC, "trace-ok")
+	w := httptest.NewRecorder()
+
+	OK(ctx, w, map[string]int{"id": 1})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	if w.Header().Get(chain.XRquestID) != "trace-ok" {
+		t.Fatalf("unexpected trace header: %q", w.Header().Get(chain.XRquestID))
+	}
+
+	var body Body
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Code != CodeOK || body.TraceID != "trace-ok" {
+		t.Fatalf("unexpected body: %+v", body)
+	}
+}
+
+func TestFail(t *testing.T) {
+	ctx := chain.WithContext(chain.BC, "trace-fail")
+
+	cases := []struct {
+		err    error
+		status int
+		code   int
+	}{
+		{errOrderNotFound, http.StatusNotFound, 10404},
+		{fmt.Errorf("query order: %w", errOrderNotFound.Wrap(errors.New("sql: no rows"))), http.StatusNotFound, 10404},
+		{ErrBadRequest.WithMsg("id %d invalid", 7), http.StatusBadRequest, http.StatusBadRequest},
+		{errors.New("boom"), http.StatusInternalServerError, http.StatusInternalServerError},
+	}
+
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		Fail(ctx, w, c.err)
+
+		if w.Code != c.status {
+			t.Fatalf("%v: unexpected status: %d", c.err, w.Code)
+		}
+
+		var body Body
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if body.Code != c.code || body.TraceID != "trace-fail" {
+			t.Fatalf("%v: unexpected body: %+v", c.err, body)
+		}
+		t.Log(body)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	if e, ok := Lookup(10404); !ok || e != errOrderNotFound {
+		t.Fatal("Lookup 10404 failed")
+	}
+
+	if !errors.Is(errOrderNotFound.Wrap(errors.New("x")), errOrderNotFound) {
+		t.Fatal("errors.Is should match by code")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicate Register should panic")
+		}
+	}()
+	Register(10404, http.StatusNotFound, "duplicate")
+}
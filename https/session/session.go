@@ -0,0 +1,348 @@
+// Package session provides cookie based login sessions backed by redis hashes.
+package session
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options 会话配置
+type Options struct {
+	Store Store // 必填, RedisStore or MemoryStore
+
+	// Secret cookie 签名密钥, 必填, 推荐 32 字节以上随机值
+	Secret []byte
+	// EncryptKey 非空时 cookie 中的 session id 用 AES-GCM 加密, 长度 16 / 24 / 32 字节
+	EncryptKey []byte
+
+	CookieName string        // 默认 sid
+	Path       string        // 默认 /
+	Domain     string        //
+	Secure     bool          // 只在 https 下发送, 线上建议开启
+	SameSite   http.SameSite // 默认 http.SameSiteLaxMode
+
+	// TTL 空闲过期时间, 每次请求都会顺延 (滑动过期), 默认 24h
+	TTL time.Duration
+}
+
+// Manager 会话管理, 通过 Handler 挂到路由上, handler 内用 FromContext 读写会话
+//
+//	manager, err := session.NewManager(session.Options{Store: session.NewRedisStore(r, ""), Secret: secret})
+//	handler = middleware.Chain(mux, manager.Handler)
+//
+//	func login(w http.ResponseWriter, r *http.Request) {
+//		s := session.FromContext(r.Context())
+//		s.Renew() // 登录后更换 session id, 防止会话固定攻击
+//		s.Set("uid", uid)
+//	}
+type Manager struct {
+	options Options
+	aead    cipher.AEAD
+}
+
+// NewManager 创建会话管理
+func NewManager(options Options) (*Manager, error) {
+	if options.Store == nil {
+		return nil, errors.New("session: store is nil")
+	}
+	if len(options.Secret) == 0 {
+		return nil, errors.New("session: secret is empty")
+	}
+	if options.CookieName == "" {
+		options.CookieName = "sid"
+	}
+	if options.Path == "" {
+		options.Path = "/"
+	}
+	if options.SameSite == 0 {
+		options.SameSite = http.SameSiteLaxMode
+	}
+	if options.TTL <= 0 {
+		options.TTL = 24 * time.Hour
+	}
+
+	m := &Manager{options: options}
+	if len(options.EncryptKey) > 0 {
+		block, err := aes.NewCipher(options.EncryptKey)
+		if err != nil {
+			return nil, err
+		}
+		if m.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Session 单个请求内的会话, 修改在响应写出前统一提交
+type Session struct {
+	mu        sync.Mutex
+	id        string
+	values    map[string]string
+	deleted   map[string]struct{}
+	dirty     bool
+	stored    bool   // store 中已经存在
+	oldID     string // Renew 之前的 id, 提交时删除
+	destroyed bool
+}
+
+type sessionKey struct{}
+
+// FromContext 获取当前请求的会话, 没有挂 Manager.Handler 时返回 nil
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
+
+// ID 当前 session id
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// Get 读取字段
+func (s *Session) Get(key string) (value string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok = s.values[key]
+	return
+}
+
+// Values 全部字段的副本
+func (s *Session) Values() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.values)
+}
+
+// Set 写入字段
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	delete(s.deleted, key)
+	s.dirty = true
+}
+
+// Delete 删除字段
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.deleted[key] = struct{}{}
+		s.dirty = true
+	}
+}
+
+// Renew 保留数据更换 session id, 登录或权限变化时调用
+func (s *Session) Renew() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stored && s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = newID()
+	s.stored = false
+	s.dirty = true
+	clear(s.deleted)
+}
+
+// Destroy 删除会话并清除 cookie, 用于退出登录
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.values)
+	clear(s.deleted)
+	s.destroyed = true
+}
+
+// Handler 会话中间件, 可直接作为 middleware.Middleware 使用
+func (m *Manager) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := m.load(r)
+		ctx := context.WithValue(r.Context(), sessionKey{}, s)
+
+		sw := &sessionWriter{ResponseWriter: w, commit: func() { m.commit(ctx, w, s) }}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		sw.commitOnce()
+	})
+}
+
+// load cookie 无效或会话已过期时生成新的空会话, 不会沿用客户端给的 id
+func (m *Manager) load(r *http.Request) *Session {
+	s := &Session{values: map[string]string{}, deleted: map[string]struct{}{}}
+
+	if cookie, err := r.Cookie(m.options.CookieName); err == nil {
+		if id, ok := m.decode(cookie.Value); ok {
+			values, err := m.options.Store.Load(r.Context(), id)
+			if err != nil {
+				// store 异常按新会话处理, 提交时也大概率失败, 日志里能看到
+				slog.ErrorContext(r.Context(), "session Store.Load error", "error", err)
+			} else if len(values) > 0 {
+				s.id, s.values, s.stored = id, values, true
+				return s
+			}
+		} else {
+			slog.WarnContext(r.Context(), "session cookie invalid", "cookie", m.options.CookieName)
+		}
+	}
+
+	s.id = newID()
+	return s
+}
+
+// commit 保存会话并写 cookie, 必须在响应头写出之前调用
+func (m *Manager) commit(ctx context.Context, w http.ResponseWriter, s *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	store := m.options.Store
+	if s.oldID != "" {
+		if err := store.Delete(ctx, s.oldID); err != nil {
+			slog.ErrorContext(ctx, "session Store.Delete old id error", "error", err)
+		}
+	}
+
+	if s.destroyed || len(s.values) == 0 {
+		if s.stored {
+			if err := store.Delete(ctx, s.id); err != nil {
+				slog.ErrorContext(ctx, "session Store.Delete error", "error", err)
+			}
+		}
+		// 没有数据的会话不落存储, 也不下发 cookie
+		if s.stored || s.destroyed || s.oldID != "" {
+			http.SetCookie(w, m.cookie("", -1))
+		}
+		return
+	}
+
+	var err error
+	if s.dirty {
+		err = store.Save(ctx, s.id, s.values, slices.Collect(maps.Keys(s.deleted)), m.options.TTL)
+	} else {
+		err = store.Touch(ctx, s.id, slices.Collect(maps.Keys(s.values)), m.options.TTL)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "session Store save error", "error", err, "dirty", s.dirty)
+		return
+	}
+
+	value, err := m.encode(s.id)
+	if err != nil {
+		slog.ErrorContext(ctx, "session cookie encode error", "error", err)
+		return
+	}
+	http.SetCookie(w, m.cookie(value, int(m.options.TTL/time.Second)))
+}
+
+func (m *Manager) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     m.options.CookieName,
+		Value:    value,
+		Path:     m.options.Path,
+		Domain:   m.options.Domain,
+		MaxAge:   maxAge,
+		Secure:   m.options.Secure,
+		HttpOnly: true,
+		SameSite: m.options.SameSite,
+	}
+}
+
+// encode cookie 值格式 payload.signature, payload 为 id 或 AES-GCM 加密后的 id
+func (m *Manager) encode(id string) (string, error) {
+	payload := id
+	if m.aead != nil {
+		nonce := make([]byte, m.aead.NonceSize(), m.aead.NonceSize()+len(id)+m.aead.Overhead())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		payload = base64.RawURLEncoding.EncodeToString(m.aead.Seal(nonce, nonce, []byte(id), []byte(m.options.CookieName)))
+	}
+	return payload + "." + m.sign(payload), nil
+}
+
+func (m *Manager) decode(value string) (id string, ok bool) {
+	payload, signature, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(m.sign(payload))) {
+		return "", false
+	}
+	if m.aead == nil {
+		return payload, payload != ""
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil || len(sealed) < m.aead.NonceSize() {
+		return "", false
+	}
+	nonce, ciphertext := sealed[:m.aead.NonceSize()], sealed[m.aead.NonceSize():]
+	plain, err := m.aead.Open(nil, nonce, ciphertext, []byte(m.options.CookieName))
+	if err != nil {
+		return "", false
+	}
+	return string(plain), len(plain) > 0
+}
+
+// sign cookie 名参与签名, 避免不同 cookie 之间互相替换
+func (m *Manager) sign(payload string) string {
+	mac := hmac.New(sha256.New, m.options.Secret)
+	mac.Write([]byte(m.options.CookieName + "=" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newID 128 bit 随机 session id
+func newID() string {
+	return rand.Text()
+}
+
+// sessionWriter 在响应头写出前提交会话, 保证 Set-Cookie 生效
+type sessionWriter struct {
+	http.ResponseWriter
+	commit    func()
+	committed bool
+}
+
+func (sw *sessionWriter) commitOnce() {
+	if !sw.committed {
+		sw.committed = true
+		sw.commit()
+	}
+}
+
+func (sw *sessionWriter) WriteHeader(status int) {
+	sw.commitOnce()
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *sessionWriter) Write(data []byte) (int, error) {
+	sw.commitOnce()
+	return sw.ResponseWriter.Write(data)
+}
+
+// Flush 支持下游 w.(http.Flusher) 断言, 直接 Flush 也会写出响应头, 同样需要先提交
+func (sw *sessionWriter) Flush() {
+	_ = sw.FlushError()
+}
+
+// FlushError 同 Flush, 供 http.ResponseController 使用并返回错误
+func (sw *sessionWriter) FlushError() error {
+	sw.commitOnce()
+	return http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+// Unwrap 支持 http.ResponseController
+func (sw *sessionWriter) Unwrap() http.ResponseWriter { return sw.ResponseWriter }
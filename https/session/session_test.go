@@ -0,0 +1,153 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/wangzhione/sbp/chain"
+	"github.com/wangzhione/sbp/helper/rediser"
+	"github.com/wangzhione/sbp/helper/rediser/redistest"
+)
+
+func newTestHandler(t *testing.T, store Store, encryptKey []byte) http.Handler {
+	t.Helper()
+
+	manager, err := NewManager(Options{Store: store, Secret: []byte("test-secret"), EncryptKey: encryptKey, TTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		s := FromContext(r.Context())
+		s.Renew()
+		s.Set("uid", r.URL.Query().Get("uid"))
+		_, _ = w.Write([]byte(s.ID()))
+	})
+	mux.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		uid, _ := FromContext(r.Context()).Get("uid")
+		_, _ = w.Write([]byte(uid))
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).Set("uid", "9")
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			t.Error("session writer is not http.Flusher")
+			return
+		}
+		// Flush 写出响应头之前提交会话
+		flusher.Flush()
+		_, _ = w.Write([]byte("streamed"))
+	})
+	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).Destroy()
+	})
+	return manager.Handler(mux)
+}
+
+func serve(handler http.Handler, path string, cookie *http.Cookie) (*httptest.ResponseRecorder, *http.Cookie) {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	for _, c := range w.Result().Cookies() {
+		if c.Name == "sid" {
+			return w, c
+		}
+	}
+	return w, nil
+}
+
+func testSessionFlow(t *testing.T, store Store, encryptKey []byte) {
+	handler := newTestHandler(t, store, encryptKey)
+
+	// 没有数据的请求不下发 cookie
+	if _, cookie := serve(handler, "/me", nil); cookie != nil {
+		t.Fatalf("anonymous request set cookie %v", cookie)
+	}
+
+	_, first := serve(handler, "/login?uid=7", nil)
+	if first == nil || first.MaxAge != 60 || !first.HttpOnly {
+		t.Fatalf("login cookie %v", first)
+	}
+
+	w, second := serve(handler, "/me", first)
+	if w.Body.String() != "7" || second == nil {
+		t.Fatalf("me body %q cookie %v", w.Body.String(), second)
+	}
+
+	// 再次登录更换 id, 旧 cookie 失效
+	w, renewed := serve(handler, "/login?uid=8", second)
+	if renewed == nil || renewed.Value == second.Value {
+		t.Fatalf("renew cookie %v", renewed)
+	}
+	if w, _ = serve(handler, "/me", second); w.Body.String() != "" {
+		t.Fatalf("old session still valid: %q", w.Body.String())
+	}
+	if w, _ = serve(handler, "/me", renewed); w.Body.String() != "8" {
+		t.Fatalf("renewed session body %q", w.Body.String())
+	}
+
+	// 篡改签名
+	forged := *renewed
+	forged.Value += "x"
+	if w, _ = serve(handler, "/me", &forged); w.Body.String() != "" {
+		t.Fatalf("forged cookie accepted: %q", w.Body.String())
+	}
+
+	_, cleared := serve(handler, "/logout", renewed)
+	if cleared == nil || cleared.MaxAge >= 0 {
+		t.Fatalf("logout cookie %v", cleared)
+	}
+	if w, _ = serve(handler, "/me", renewed); w.Body.String() != "" {
+		t.Fatalf("destroyed session still valid: %q", w.Body.String())
+	}
+}
+
+func TestManagerMemory(t *testing.T) {
+	testSessionFlow(t, NewMemoryStore(), nil)
+}
+
+func TestManagerEncrypted(t *testing.T) {
+	testSessionFlow(t, NewMemoryStore(), []byte("0123456789abcdef0123456789abcdef"))
+}
+
+func TestManagerFlush(t *testing.T) {
+	handler := newTestHandler(t, NewMemoryStore(), nil)
+	w, cookie := serve(handler, "/stream", nil)
+	if w.Body.String() != "streamed" || cookie == nil || !w.Flushed {
+		t.Fatalf("body %q cookie %v flushed %v", w.Body.String(), cookie, w.Flushed)
+	}
+	if w, _ = serve(handler, "/me", cookie); w.Body.String() != "9" {
+		t.Fatalf("me body %q", w.Body.String())
+	}
+}
+
+func TestMemoryStoreExpire(t *testing.T) {
+	store := NewMemoryStore()
+	ctx := chain.Context()
+	_ = store.Save(ctx, "id", map[string]string{"k": "v"}, nil, 20*time.Millisecond)
+
+	time.Sleep(10 * time.Millisecond)
+	_ = store.Touch(ctx, "id", []string{"k"}, 20*time.Millisecond)
+	time.Sleep(15 * time.Millisecond)
+	if values, _ := store.Load(ctx, "id"); values["k"] != "v" {
+		t.Fatal("touch should extend expiration")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if values, _ := store.Load(ctx, "id"); len(values) != 0 {
+		t.Fatalf("expired values %v", values)
+	}
+}
+
+func TestManagerRedis(t *testing.T) {
+	r := redistest.Require(t, rediser.NewDefaultRedis)
+
+	testSessionFlow(t, NewRedisStore(r, "test:session:"), nil)
+}
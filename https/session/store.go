@@ -0,0 +1,148 @@
+package session
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/wangzhione/sbp/helper/rediser"
+)
+
+// Store 会话数据存储, 线上用 RedisStore, 单测或单实例用 MemoryStore
+type Store interface {
+	// Load 不存在或已过期返回空 map 和 nil error
+	Load(ctx context.Context, id string) (map[string]string, error)
+	// Save 写入 values, 删除 deleted, 并把所有字段的过期时间刷新为 ttl
+	Save(ctx context.Context, id string, values map[string]string, deleted []string, ttl time.Duration) error
+	// Touch 只刷新过期时间, fields 为会话当前全部字段
+	Touch(ctx context.Context, id string, fields []string, ttl time.Duration) error
+	Delete(ctx context.Context, id string) error
+}
+
+// RedisStore 每个会话一个 redis hash, 字段级过期 HExpire 需要 Redis 7.4+
+type RedisStore struct {
+	R      *rediser.Client
+	Prefix string // 默认 session:
+}
+
+// NewRedisStore prefix 为空使用 session:
+func NewRedisStore(r *rediser.Client, prefix string) *RedisStore {
+	if prefix == "" {
+		prefix = "session:"
+	}
+	return &RedisStore{R: r, Prefix: prefix}
+}
+
+func (s *RedisStore) Load(ctx context.Context, id string) (map[string]string, error) {
+	return s.R.HGetAll(ctx, s.Prefix+id)
+}
+
+// Save HDel / HSet / HExpire 放在一个 MULTI 事务中一次往返完成, 不会留下只写了一半的会话
+func (s *RedisStore) Save(ctx context.Context, id string, values map[string]string, deleted []string, ttl time.Duration) error {
+	key := s.Prefix + id
+	_, err := s.R.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(deleted) > 0 {
+			pipe.HDel(ctx, key, deleted...)
+		}
+		if len(values) > 0 {
+			pipe.HSet(ctx, key, values)
+			pipe.HExpire(ctx, key, ttl, slices.Collect(maps.Keys(values))...)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "RedisStore Save error", "key", key, "error", err)
+	}
+	return err
+}
+
+func (s *RedisStore) Touch(ctx context.Context, id string, fields []string, ttl time.Duration) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	_, err := s.R.HExpire(ctx, s.Prefix+id, ttl, fields...)
+	return err
+}
+
+func (s *RedisStore) Delete(ctx context.Context, id string) error {
+	return s.R.Del(ctx, s.Prefix+id)
+}
+
+// MemoryStore 进程内存储, 过期数据在访问时惰性清理
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]*memorySession
+}
+
+type memorySession struct {
+	values   map[string]string
+	expireAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{sessions: make(map[string]*memorySession)}
+}
+
+func (s *MemoryStore) Load(ctx context.Context, id string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ms := s.get(id)
+	if ms == nil {
+		return map[string]string{}, nil
+	}
+	return maps.Clone(ms.values), nil
+}
+
+func (s *MemoryStore) Save(ctx context.Context, id string, values map[string]string, deleted []string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ms := s.get(id)
+	if ms == nil {
+		ms = &memorySession{values: make(map[string]string, len(values))}
+		s.sessions[id] = ms
+	}
+	for _, field := range deleted {
+		delete(ms.values, field)
+	}
+	maps.Copy(ms.values, values)
+	ms.expireAt = time.Now().Add(ttl)
+	if len(ms.values) == 0 {
+		delete(s.sessions, id)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Touch(ctx context.Context, id string, fields []string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ms := s.get(id); ms != nil {
+		ms.expireAt = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sessions, id)
+	return nil
+}
+
+// get 调用方持有锁
+func (s *MemoryStore) get(id string) *memorySession {
+	ms := s.sessions[id]
+	if ms != nil && time.Now().After(ms.expireAt) {
+		delete(s.sessions, id)
+		return nil
+	}
+	return ms
+}
@@ -0,0 +1,210 @@
+package https
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrSSEClosed 客户端断开或服务端主动 Close 之后继续 Send
+	ErrSSEClosed = errors.New("https: sse stream closed")
+	// ErrSSEField Event.ID / Event.Event 包含 CR 或 LF, 会截断事件或注入其他字段
+	ErrSSEField = errors.New("https: sse id or event contains CR or LF")
+)
+
+// Event Server-Sent Events 单条消息
+type Event struct {
+	ID    string        // 客户端重连时通过 Last-Event-ID 带回
+	Event string        // 事件名, 为空客户端按 message 处理
+	Data  any           // string / []byte 原样输出, 其他类型 JSON 编码
+	Retry time.Duration // 建议客户端重连间隔
+}
+
+// SSE 基于 http.ResponseWriter 的 Server-Sent Events 流
+//
+//	stream, err := https.NewSSE(w, r, 15*time.Second)
+//	if err != nil { ... }
+//	defer stream.Close()
+//	for progress := range ch {
+//		if err := stream.Send(https.Event{Event: "progress", Data: progress}); err != nil {
+//			return // 客户端已断开
+//		}
+//	}
+type SSE struct {
+	LastEventID string // 客户端断线重连时携带的 Last-Event-ID
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+
+	mu     sync.Mutex
+	err    error // 首次写失败错误, 之后所有 Send 直接返回
+	closed bool
+	events int64
+	begin  time.Time
+}
+
+// NewSSE 写出 text/event-stream 响应头并立即 flush
+// heartbeat > 0 时后台定期发送注释行, 防止 LB / nginx 因空闲断开连接; 请求 context 结束 (客户端断开) 后流自动关闭
+func NewSSE(w http.ResponseWriter, r *http.Request, heartbeat time.Duration) (*SSE, error) {
+	ctx, cancel := context.WithCancel(r.Context())
+	s := &SSE{
+		LastEventID: r.Header.Get("Last-Event-ID"),
+		ctx:         ctx,
+		cancel:      cancel,
+		w:           w,
+		rc:          http.NewResponseController(w),
+		begin:       time.Now(),
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no") // 关闭 nginx 缓冲
+	// 长连接不受 ServeOptions.WriteTimeout 限制, 不支持时忽略
+	_ = s.rc.SetWriteDeadline(time.Time{})
+	w.WriteHeader(http.StatusOK)
+
+	if err := s.rc.Flush(); err != nil {
+		cancel()
+		slog.ErrorContext(ctx, "SSE Flush not supported error", "error", err, "path", r.URL.Path)
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "SSE stream open", "path", r.URL.Path, "LastEventID", s.LastEventID, "heartbeat", heartbeat)
+
+	if heartbeat > 0 {
+		go s.heartbeat(heartbeat)
+	}
+	return s, nil
+}
+
+// Context 流的生命周期, 客户端断开或 Close 后 Done
+func (s *SSE) Context() context.Context { return s.ctx }
+
+// Done 客户端断开或 Close 后关闭
+func (s *SSE) Done() <-chan struct{} { return s.ctx.Done() }
+
+// Send 发送一条事件并 flush
+// ID / Event 包含 CR 或 LF 时返回 ErrSSEField; Data 中的 \r\n / \r / \n 都按换行拆成多个 data: 字段
+func (s *SSE) Send(event Event) error {
+	if strings.ContainsAny(event.ID, "\r\n") || strings.ContainsAny(event.Event, "\r\n") {
+		return ErrSSEField
+	}
+
+	var buf bytes.Buffer
+	if event.ID != "" {
+		buf.WriteString("id: " + event.ID + "\n")
+	}
+	if event.Event != "" {
+		buf.WriteString("event: " + event.Event + "\n")
+	}
+	if event.Retry > 0 {
+		buf.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+
+	var data string
+	switch value := event.Data.(type) {
+	case nil:
+	case string:
+		data = value
+	case []byte:
+		data = string(value)
+	default:
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		data = string(raw)
+	}
+	// 多行数据每行一个 data: 字段, 客户端会用 \n 拼回来; SSE 中单独的 \r 也是行结束符, 需要一起拆开
+	data = strings.ReplaceAll(strings.ReplaceAll(data, "\r\n", "\n"), "\r", "\n")
+	for line := range strings.SplitSeq(data, "\n") {
+		buf.WriteString("data: " + line + "\n")
+	}
+	buf.WriteByte('\n')
+
+	err := s.write(buf.Bytes())
+	if err == nil {
+		s.mu.Lock()
+		s.events++
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// Close 结束流并记录生命周期日志, 可重复调用
+func (s *SSE) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+	if s.err == nil {
+		s.err = ErrSSEClosed
+	}
+	reason := context.Cause(s.ctx)
+	s.cancel()
+	if reason == nil {
+		reason = s.err
+	}
+
+	slog.InfoContext(s.ctx, "SSE stream close",
+		"reason", reason,
+		"events", s.events,
+		"elapsed", time.Since(s.begin).String(),
+	)
+}
+
+func (s *SSE) write(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	if err := s.ctx.Err(); err != nil {
+		s.err = ErrSSEClosed
+		return s.err
+	}
+
+	if _, err := s.w.Write(data); err != nil {
+		s.err = err
+		return err
+	}
+	if err := s.rc.Flush(); err != nil {
+		s.err = err
+		return err
+	}
+	return nil
+}
+
+func (s *SSE) heartbeat(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			// 冒号开头是注释行, EventSource 会忽略
+			if err := s.write([]byte(": ping\n\n")); err != nil {
+				slog.InfoContext(s.ctx, "SSE heartbeat stop", "reason", err)
+				return
+			}
+		}
+	}
+}
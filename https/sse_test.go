@@ -0,0 +1,85 @@
+package https
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wangzhione/sbp/chain"
+)
+
+func TestSSE(t *testing.T) {
+	closed := make(chan error, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, _ = chain.Request(r)
+
+		stream, err := NewSSE(w, r, 20*time.Millisecond)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer stream.Close()
+
+		_ = stream.Send(Event{ID: "1", Event: "progress", Data: map[string]int{"percent": 50}, Retry: time.Second})
+		if err := stream.Send(Event{ID: "2\nevent: fake", Data: "x"}); err != ErrSSEField {
+			t.Errorf("id with LF err = %v", err)
+		}
+		// 单独的 \r 也是行结束符, 不能借此注入字段
+		_ = stream.Send(Event{ID: "2", Data: "line1\nline2\revent: fake\r\nline4"})
+
+		// 等待客户端断开
+		<-stream.Done()
+		time.Sleep(10 * time.Millisecond)
+		closed <- stream.Send(Event{Data: "after close"})
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type: %q", ct)
+	}
+
+	var lines []string
+	heartbeat := false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == ": ping" {
+			heartbeat = true
+			break
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	cancel()
+
+	want := "id: 1|event: progress|retry: 1000|data: {\"percent\":50}|id: 2|data: line1|data: line2|data: event: fake|data: line4"
+	if got := strings.Join(lines, "|"); got != want {
+		t.Fatalf("got %s\nwant %s", got, want)
+	}
+	if !heartbeat {
+		t.Fatal("heartbeat not received")
+	}
+
+	select {
+	case err := <-closed:
+		if err != ErrSSEClosed {
+			t.Fatalf("send after disconnect: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("handler not notified of disconnect")
+	}
+}
@@ -0,0 +1,219 @@
+package https
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wangzhione/sbp/util/idhash"
+)
+
+// CacheRule Cache-Control 策略, Pattern 以 / 结尾为路径前缀匹配, 否则按 path.Match 匹配完整路径或文件名
+//
+//	{Pattern: "/assets/", Value: "public, max-age=31536000, immutable"}
+//	{Pattern: "*.html", Value: "no-cache"}
+type CacheRule struct {
+	Pattern string
+	Value   string
+}
+
+// StaticOptions 静态资源配置
+type StaticOptions struct {
+	Index        string      // 目录默认文件, 默认 index.html
+	SPA          bool        // 单页应用: 找不到且没有扩展名的路径回退到根目录 Index, 交给前端路由
+	CacheRules   []CacheRule // 按顺序匹配, 第一个命中的生效
+	CacheDefault string      // 没有命中 CacheRules 时的 Cache-Control, 默认 no-cache (每次协商 ETag)
+	Gzip         bool        // 客户端支持 gzip 且存在 {file}.gz 时直接返回预压缩文件
+}
+
+// Static 基于 fs.FS (包括 embed.FS / os.DirFS) 的静态资源 handler, 使用 idhash.MD5 内容摘要作为强 ETag
+//
+//	//go:embed dist
+//	var dist embed.FS
+//	sub, _ := fs.Sub(dist, "dist")
+//	mux.Handle("/admin/", http.StripPrefix("/admin", https.Static(sub, https.StaticOptions{SPA: true, Gzip: true})))
+func Static(fsys fs.FS, options StaticOptions) http.Handler {
+	if options.Index == "" {
+		options.Index = "index.html"
+	}
+	if options.CacheDefault == "" {
+		options.CacheDefault = "no-cache"
+	}
+	s := &static{fsys: fsys, options: options}
+	return s
+}
+
+type static struct {
+	fsys    fs.FS
+	options StaticOptions
+	etags   sync.Map // map[etagKey]string
+}
+
+// etagKey 文件变化 (size / modtime) 后自动重新计算, embed.FS modtime 恒为零值, 内容也不会变
+type etagKey struct {
+	name    string
+	size    int64
+	modtime time.Time
+}
+
+func (s *static) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	name, ok := staticName(r.URL.Path)
+	if !ok {
+		slog.WarnContext(r.Context(), "Static reject path", "path", r.URL.Path, "remote", r.RemoteAddr)
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+
+	name, info, err := s.resolve(name)
+	if errors.Is(err, fs.ErrNotExist) && s.options.SPA && path.Ext(name) == "" {
+		name, info, err = s.resolve(s.options.Index)
+	}
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		slog.ErrorContext(r.Context(), "Static open error", "error", err, "name", name)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Cache-Control", s.cacheControl(name))
+
+	served := name
+	if s.options.Gzip {
+		header.Add("Vary", "Accept-Encoding")
+		if acceptGzip(r) {
+			if gzinfo, err := fs.Stat(s.fsys, name+".gz"); err == nil && !gzinfo.IsDir() {
+				served, info = name+".gz", gzinfo
+				header.Set("Content-Encoding", "gzip")
+			}
+		}
+	}
+
+	// Content-Type 按原始文件扩展名, 不能按 .gz
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		header.Set("Content-Type", ctype)
+	}
+
+	content, etag, err := s.open(served, info)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Static read error", "error", err, "name", served)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	if closer, ok := content.(io.Closer); ok {
+		defer closer.Close()
+	}
+	header.Set("ETag", etag)
+
+	// ServeContent 负责 If-None-Match / If-Modified-Since / Range
+	http.ServeContent(w, r, name, info.ModTime(), content)
+}
+
+// staticName url path 转 fs.FS 文件名, 拒绝 .. 等路径穿越
+func staticName(urlpath string) (name string, ok bool) {
+	if strings.ContainsAny(urlpath, "\\\x00") {
+		return "", false
+	}
+	for segment := range strings.SplitSeq(urlpath, "/") {
+		if segment == ".." {
+			return "", false
+		}
+	}
+
+	name = strings.Trim(path.Clean("/"+urlpath), "/")
+	if name == "" {
+		name = "."
+	}
+	return name, fs.ValidPath(name)
+}
+
+// resolve 目录返回目录下的 Index
+func (s *static) resolve(name string) (string, fs.FileInfo, error) {
+	info, err := fs.Stat(s.fsys, name)
+	if err != nil {
+		return name, nil, err
+	}
+	if info.IsDir() {
+		name = path.Join(name, s.options.Index)
+		if info, err = fs.Stat(s.fsys, name); err != nil {
+			return name, nil, err
+		}
+		if info.IsDir() {
+			return name, nil, fs.ErrNotExist
+		}
+	}
+	return name, info, nil
+}
+
+// open 返回可 Seek 的内容和 ETag, ETag 按 name + size + modtime 缓存
+func (s *static) open(name string, info fs.FileInfo) (io.ReadSeeker, string, error) {
+	key := etagKey{name: name, size: info.Size(), modtime: info.ModTime()}
+	etag, cached := s.etags.Load(key)
+
+	file, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, "", err
+	}
+
+	content, seekable := file.(io.ReadSeeker)
+	if cached && seekable {
+		return content, etag.(string), nil
+	}
+
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	if !cached {
+		etag = `"` + idhash.MD5(data) + `"`
+		s.etags.Store(key, etag)
+	}
+	return bytes.NewReader(data), etag.(string), nil
+}
+
+// cacheControl 按实际返回的文件匹配, SPA 回退时按 Index 匹配
+func (s *static) cacheControl(name string) string {
+	for _, rule := range s.options.CacheRules {
+		if strings.HasSuffix(rule.Pattern, "/") {
+			if strings.HasPrefix("/"+name, rule.Pattern) {
+				return rule.Value
+			}
+			continue
+		}
+		if matched, _ := path.Match(rule.Pattern, "/"+name); matched {
+			return rule.Value
+		}
+		if matched, _ := path.Match(rule.Pattern, path.Base(name)); matched {
+			return rule.Value
+		}
+	}
+	return s.options.CacheDefault
+}
+
+func acceptGzip(r *http.Request) bool {
+	for part := range strings.SplitSeq(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package https
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestStatic(t *testing.T) {
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	_, _ = gw.Write([]byte("console.log('app')"))
+	_ = gw.Close()
+
+	fsys := fstest.MapFS{
+		"index.html":       {Data: []byte("<html>index</html>")},
+		"assets/app.js":    {Data: []byte("console.log('app')")},
+		"assets/app.js.gz": {Data: gz.Bytes()},
+		"docs/index.html":  {Data: []byte("<html>docs</html>")},
+		"assets/style.css": {Data: []byte("body{}")},
+	}
+
+	h := Static(fsys, StaticOptions{
+		SPA:  true,
+		Gzip: true,
+		CacheRules: []CacheRule{
+			{Pattern: "/assets/", Value: "public, max-age=31536000, immutable"},
+			{Pattern: "*.html", Value: "no-cache"},
+		},
+	})
+
+	do := func(path string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.URL.Path = path
+		for key, value := range header {
+			req.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("/assets/style.css", nil)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || w.Body.String() != "body{}" || etag == "" ||
+		w.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" {
+		t.Fatalf("css: %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+
+	// ETag 协商
+	if w = do("/assets/style.css", map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified {
+		t.Fatalf("If-None-Match: %d", w.Code)
+	}
+
+	// 预压缩
+	w = do("/assets/app.js", map[string]string{"Accept-Encoding": "gzip, br"})
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Content-Type") != "text/javascript; charset=utf-8" {
+		t.Fatalf("gzip headers: %v", w.Header())
+	}
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := io.ReadAll(gr); string(data) != "console.log('app')" {
+		t.Fatalf("gzip body: %q", data)
+	}
+	if w = do("/assets/app.js", nil); w.Header().Get("Content-Encoding") != "" || w.Body.String() != "console.log('app')" {
+		t.Fatalf("plain js: %v", w.Header())
+	}
+
+	// 目录 index / SPA 回退 / 静态资源 404
+	if w = do("/docs/", nil); w.Body.String() != "<html>docs</html>" {
+		t.Fatalf("dir index: %q", w.Body.String())
+	}
+	if w = do("/orders/123", nil); w.Code != http.StatusOK || w.Body.String() != "<html>index</html>" || w.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatalf("spa fallback: %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+	if w = do("/assets/missing.js", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("missing asset: %d", w.Code)
+	}
+
+	// 路径穿越
+	for _, path := range []string{"/../index.html", "/secret/../x.txt", "/assets\\..\\index.html"} {
+		if w = do(path, nil); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: %d", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,494 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// 消息类型, 同 RFC 6455 opcode
+const (
+	continuationFrame = 0
+	TextMessage       = 1
+	BinaryMessage     = 2
+	CloseMessage      = 8
+	PingMessage       = 9
+	PongMessage       = 10
+)
+
+// 关闭状态码 RFC 6455 7.4.1
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseInternalServerErr       = 1011
+)
+
+var (
+	// ErrReadLimit 单条消息超过 Options.ReadLimit
+	ErrReadLimit = errors.New("websocket: read limit exceeded")
+	// ErrClosed 连接已经关闭
+	ErrClosed = errors.New("websocket: use of closed connection")
+)
+
+// CloseError 收到对端 close 帧
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+// IsCloseError err 是否为指定状态码的 CloseError, codes 为空时只判断是否为 CloseError
+func IsCloseError(err error, codes ...int) bool {
+	var e *CloseError
+	if !errors.As(err, &e) {
+		return false
+	}
+	if len(codes) == 0 {
+		return true
+	}
+	for _, code := range codes {
+		if e.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultReadLimit 默认单条消息最大 4MB
+var DefaultReadLimit int64 = 4 << 20
+
+// Options 连接参数, 服务端 Upgrade 和客户端 Dial 共用
+type Options struct {
+	ReadLimit    int64         // 单条消息 (合并分片后) 最大字节数, 默认 DefaultReadLimit
+	PingInterval time.Duration // > 0 时后台定期 ping, 超过 2 * PingInterval 没有收到任何帧视为连接失效
+	WriteTimeout time.Duration // 单次写超时, 默认 10s
+	CloseTimeout time.Duration // Close 等待对端回复 close 帧的时间, 默认 3s
+}
+
+func (opts *Options) withDefault() Options {
+	var options Options
+	if opts != nil {
+		options = *opts
+	}
+	if options.ReadLimit <= 0 {
+		options.ReadLimit = DefaultReadLimit
+	}
+	if options.WriteTimeout <= 0 {
+		options.WriteTimeout = 10 * time.Second
+	}
+	if options.CloseTimeout <= 0 {
+		options.CloseTimeout = 3 * time.Second
+	}
+	return options
+}
+
+// Conn WebSocket 连接
+// 读: 同一时间只允许一个 goroutine 调用 ReadMessage; 写: 并发安全
+// ping / pong / close 控制帧在 ReadMessage 内部自动处理, 所以业务需要一直有 goroutine 在读
+type Conn struct {
+	ctx     context.Context // 携带 trace id
+	conn    net.Conn
+	br      *bufio.Reader
+	server  bool
+	options Options
+
+	Subprotocol string // 握手协商出的子协议
+
+	wmu       sync.Mutex
+	closeSent bool
+
+	reading   atomic.Bool
+	closeRecv chan struct{} // 收到对端 close 帧后关闭
+	recvOnce  sync.Once
+	stop      chan struct{} // 连接结束后关闭, 用于停止 ping
+	closeOnce sync.Once
+	closeErr  error
+
+	begin   time.Time
+	reads   atomic.Int64
+	writes  atomic.Int64
+	logOnce sync.Once
+}
+
+func newConn(ctx context.Context, conn net.Conn, br *bufio.Reader, server bool, opts *Options) *Conn {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	c := &Conn{
+		ctx:       ctx,
+		conn:      conn,
+		br:        br,
+		server:    server,
+		options:   opts.withDefault(),
+		closeRecv: make(chan struct{}),
+		stop:      make(chan struct{}),
+		begin:     time.Now(),
+	}
+	if c.options.PingInterval > 0 {
+		go c.keepalive()
+	}
+	return c
+}
+
+// Context 连接级 context, 携带 trace id, 用于业务日志
+func (c *Conn) Context() context.Context { return c.ctx }
+
+// RemoteAddr 对端地址
+func (c *Conn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// ReadMessage 读取一条完整消息 (自动合并分片), 返回 TextMessage 或 BinaryMessage
+// 对端关闭时返回 *CloseError
+func (c *Conn) ReadMessage() (messageType int, data []byte, err error) {
+	c.reading.Store(true)
+	defer c.reading.Store(false)
+
+	for {
+		if c.options.PingInterval > 0 {
+			_ = c.conn.SetReadDeadline(time.Now().Add(2 * c.options.PingInterval))
+		}
+
+		fin, opcode, payload, err := c.readFrame(c.options.ReadLimit - int64(len(data)))
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case PingMessage:
+			if err = c.writeFrame(PongMessage, payload); err != nil && !errors.Is(err, ErrClosed) {
+				return 0, nil, err
+			}
+
+		case PongMessage:
+			// 收到任意帧已经刷新了读超时, 这里不需要额外处理
+
+		case CloseMessage:
+			return 0, nil, c.handleClose(payload)
+
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return 0, nil, c.fail(CloseProtocolError, errors.New("websocket: expected continuation frame"))
+			}
+			messageType = opcode
+			data = payload
+			if fin {
+				return c.message(messageType, data)
+			}
+
+		case continuationFrame:
+			if messageType == 0 {
+				return 0, nil, c.fail(CloseProtocolError, errors.New("websocket: unexpected continuation frame"))
+			}
+			data = append(data, payload...)
+			if fin {
+				return c.message(messageType, data)
+			}
+
+		default:
+			return 0, nil, c.fail(CloseProtocolError, fmt.Errorf("websocket: unknown opcode %d", opcode))
+		}
+	}
+}
+
+func (c *Conn) message(messageType int, data []byte) (int, []byte, error) {
+	if messageType == TextMessage && !utf8.Valid(data) {
+		return 0, nil, c.fail(CloseInvalidFramePayloadData, errors.New("websocket: invalid utf8 text message"))
+	}
+	c.reads.Add(1)
+	return messageType, data, nil
+}
+
+// ReadJSON 读取一条消息并 JSON 解码
+func (c *Conn) ReadJSON(v any) error {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WriteMessage 发送一条完整消息, messageType 为 TextMessage 或 BinaryMessage
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+	err := c.writeFrame(messageType, data)
+	if err == nil {
+		c.writes.Add(1)
+	}
+	return err
+}
+
+// WriteJSON JSON 编码后以 TextMessage 发送
+func (c *Conn) WriteJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(TextMessage, data)
+}
+
+// Ping 发送 ping 帧, 对端会在其读循环中回复 pong
+func (c *Conn) Ping(data []byte) error {
+	return c.writeFrame(PingMessage, data)
+}
+
+// Close 正常关闭 CloseNormalClosure
+func (c *Conn) Close() error {
+	return c.CloseWith(CloseNormalClosure, "")
+}
+
+// CloseWith 发起关闭握手: 发送 close 帧, 等待对端回复 close 帧 (最多 CloseTimeout), 然后关闭底层连接
+func (c *Conn) CloseWith(code int, reason string) error {
+	_ = c.writeClose(code, reason)
+
+	timer := time.NewTimer(c.options.CloseTimeout)
+	defer timer.Stop()
+
+	if c.reading.Load() {
+		// 其他 goroutine 在 ReadMessage, 由它收到 close 帧后通知
+		select {
+		case <-c.closeRecv:
+		case <-c.stop:
+		case <-timer.C:
+		}
+	} else {
+		// 没有人在读, 自己把剩余帧读掉直到 close 帧
+		_ = c.conn.SetReadDeadline(time.Now().Add(c.options.CloseTimeout))
+		for {
+			if _, _, err := c.ReadMessage(); err != nil {
+				break
+			}
+		}
+	}
+
+	return c.shutdown(&CloseError{Code: code, Text: reason})
+}
+
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+	if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Text = string(payload[2:])
+	}
+	c.recvOnce.Do(func() { close(c.closeRecv) })
+
+	// 对端发起关闭, 回复同样的状态码后关闭连接; 我方发起的则由 CloseWith 收尾
+	c.wmu.Lock()
+	initiated := c.closeSent
+	c.wmu.Unlock()
+	if !initiated {
+		code := closeErr.Code
+		if code == CloseNoStatusReceived {
+			code = CloseNormalClosure
+		}
+		_ = c.writeClose(code, "")
+		_ = c.shutdown(closeErr)
+	}
+	return closeErr
+}
+
+// fail 协议错误, 发送 close 帧后直接断开
+func (c *Conn) fail(code int, err error) error {
+	_ = c.writeClose(code, err.Error())
+	_ = c.shutdown(err)
+	return err
+}
+
+func (c *Conn) writeClose(code int, reason string) error {
+	// 控制帧最大 125 字节
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], reason)
+	return c.writeFrame(CloseMessage, payload)
+}
+
+func (c *Conn) shutdown(reason error) error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		c.closeErr = c.conn.Close()
+
+		slog.InfoContext(c.ctx, "WebSocket close",
+			"remote", c.conn.RemoteAddr().String(),
+			"server", c.server,
+			"reason", reason,
+			"reads", c.reads.Load(),
+			"writes", c.writes.Load(),
+			"elapsed", time.Since(c.begin).String(),
+		)
+	})
+	return c.closeErr
+}
+
+func (c *Conn) keepalive() {
+	ticker := time.NewTicker(c.options.PingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			if err := c.Ping(nil); err != nil {
+				slog.WarnContext(c.ctx, "WebSocket keepalive ping error", "error", err, "remote", c.conn.RemoteAddr().String())
+				_ = c.shutdown(err)
+				return
+			}
+		}
+	}
+}
+
+// readFrame 读取一帧, limit 为本条消息剩余可读字节数
+func (c *Conn) readFrame(limit int64) (fin bool, opcode int, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.br, head[:]); err != nil {
+		return false, 0, nil, c.readError(err)
+	}
+
+	fin = head[0]&0x80 != 0
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, errors.New("websocket: unexpected reserved bits"))
+	}
+	opcode = int(head[0] & 0x0f)
+	masked := head[1]&0x80 != 0
+	length := int64(head[1] & 0x7f)
+
+	// 客户端发往服务端的帧必须 mask, 服务端发往客户端的帧不能 mask
+	if masked != c.server {
+		return false, 0, nil, c.fail(CloseProtocolError, errors.New("websocket: bad frame masking"))
+	}
+
+	if opcode >= CloseMessage && (!fin || length > 125) {
+		return false, 0, nil, c.fail(CloseProtocolError, errors.New("websocket: invalid control frame"))
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, c.readError(err)
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, c.readError(err)
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+		if length < 0 {
+			return false, 0, nil, c.fail(CloseProtocolError, errors.New("websocket: invalid frame length"))
+		}
+	}
+
+	if opcode < CloseMessage && length > limit {
+		return false, 0, nil, c.fail(CloseMessageTooBig, ErrReadLimit)
+	}
+
+	var maskKey [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, maskKey[:]); err != nil {
+			return false, 0, nil, c.readError(err)
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, c.readError(err)
+	}
+	if masked {
+		maskBytes(maskKey, payload)
+	}
+	return
+}
+
+// readError 网络层读错误, 连接已经不可用
+func (c *Conn) readError(err error) error {
+	select {
+	case <-c.stop:
+		return ErrClosed
+	default:
+	}
+	_ = c.shutdown(err)
+	return err
+}
+
+func (c *Conn) writeFrame(opcode int, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.closeSent {
+		return ErrClosed
+	}
+	if opcode == CloseMessage {
+		c.closeSent = true
+	}
+
+	frame := make([]byte, 0, 14+len(payload))
+	frame = append(frame, 0x80|byte(opcode))
+
+	var maskBit byte
+	if !c.server {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	if c.server {
+		frame = append(frame, payload...)
+	} else {
+		var maskKey [4]byte
+		_, _ = rand.Read(maskKey[:])
+		frame = append(frame, maskKey[:]...)
+		begin := len(frame)
+		frame = append(frame, payload...)
+		maskBytes(maskKey, frame[begin:])
+	}
+
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.options.WriteTimeout))
+	if _, err := c.conn.Write(frame); err != nil {
+		select {
+		case <-c.stop:
+			return ErrClosed
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+func maskBytes(key [4]byte, data []byte) {
+	for i := range data {
+		data[i] ^= key[i&3]
+	}
+}
@@ -0,0 +1,306 @@
+// Package websocket provides an RFC 6455 WebSocket server upgrade handler and client dialer.
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/wangzhione/sbp/chain"
+)
+
+// ErrBadHandshake 握手失败
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// keyGUID RFC 6455 1.3 固定 GUID
+const keyGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func computeAccept(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + keyGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Upgrader 服务端升级配置
+type Upgrader struct {
+	Options
+
+	// Subprotocols 服务端支持的子协议, 按客户端 Sec-WebSocket-Protocol 顺序选第一个支持的
+	Subprotocols []string
+	// CheckOrigin 校验 Origin, 为 nil 时要求 Origin 为空或者 host 与请求 Host 一致, 防止跨站劫持
+	CheckOrigin func(r *http.Request) bool
+}
+
+// Upgrade 使用默认 Upgrader 升级连接
+func Upgrade(w http.ResponseWriter, r *http.Request, opts *Options) (*Conn, error) {
+	upgrader := &Upgrader{}
+	if opts != nil {
+		upgrader.Options = *opts
+	}
+	return upgrader.Upgrade(w, r)
+}
+
+// Upgrade 将 HTTP 请求升级为 WebSocket, 失败时已经写出错误响应
+// 连接 context 复制请求的 trace id (没有则新生成), 不受请求 context 生命周期影响
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	ctx := chain.CopyTrace(r.Context())
+
+	fail := func(status int, reason string) (*Conn, error) {
+		slog.WarnContext(ctx, "WebSocket Upgrade failed", "reason", reason, "path", r.URL.Path, "remote", r.RemoteAddr)
+		http.Error(w, http.StatusText(status), status)
+		return nil, fmt.Errorf("%w: %s", ErrBadHandshake, reason)
+	}
+
+	if r.Method != http.MethodGet {
+		return fail(http.StatusMethodNotAllowed, "method not GET")
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return fail(http.StatusBadRequest, "missing upgrade headers")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusUpgradeRequired, "unsupported version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return fail(http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		return fail(http.StatusForbidden, "origin not allowed")
+	}
+
+	subprotocol := ""
+	for _, offered := range headerTokens(r.Header, "Sec-WebSocket-Protocol") {
+		for _, supported := range u.Subprotocols {
+			if offered == supported && subprotocol == "" {
+				subprotocol = supported
+			}
+		}
+	}
+
+	netConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return fail(http.StatusInternalServerError, "hijack not supported: "+err.Error())
+	}
+
+	var response strings.Builder
+	response.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	response.WriteString("Upgrade: websocket\r\nConnection: Upgrade\r\n")
+	response.WriteString("Sec-WebSocket-Accept: " + computeAccept(key) + "\r\n")
+	if subprotocol != "" {
+		response.WriteString("Sec-WebSocket-Protocol: " + subprotocol + "\r\n")
+	}
+	response.WriteString(chain.XRquestID + ": " + chain.GetTraceID(ctx) + "\r\n\r\n")
+
+	_ = netConn.SetDeadline(time.Now().Add(u.withDefault().WriteTimeout))
+	if _, err = netConn.Write([]byte(response.String())); err != nil {
+		netConn.Close()
+		slog.ErrorContext(ctx, "WebSocket Upgrade write response error", "error", err, "remote", r.RemoteAddr)
+		return nil, err
+	}
+	_ = netConn.SetDeadline(time.Time{})
+
+	c := newConn(ctx, netConn, brw.Reader, true, &u.Options)
+	c.Subprotocol = subprotocol
+	slog.InfoContext(ctx, "WebSocket open", "server", true, "path", r.URL.Path, "remote", r.RemoteAddr, "subprotocol", subprotocol)
+	return c, nil
+}
+
+// sameOrigin 浏览器跨站请求会带 Origin, 非浏览器客户端一般不带
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// Dialer 客户端配置
+type Dialer struct {
+	Options
+
+	// NetDial 建立 TCP 连接, 为 nil 时使用 net.Dialer
+	NetDial func(ctx context.Context, network, addr string) (net.Conn, error)
+	// TLSConfig wss 使用, 为 nil 时使用默认配置
+	TLSConfig *tls.Config
+	// HandshakeTimeout 握手超时, 默认 10s, ctx 的 deadline 更早时以 ctx 为准
+	HandshakeTimeout time.Duration
+	// Subprotocols 客户端希望使用的子协议
+	Subprotocols []string
+}
+
+// DefaultDialer 默认客户端
+var DefaultDialer = &Dialer{}
+
+// Dial 使用 DefaultDialer 建立连接
+func Dial(ctx context.Context, rawurl string, header http.Header) (*Conn, *http.Response, error) {
+	return DefaultDialer.Dial(ctx, rawurl, header)
+}
+
+// Dial 连接 ws:// 或 wss:// 地址, 握手失败时 *http.Response 不为 nil 便于排查
+// header 中没有 X-Request-Id 时自动带上 ctx 的 trace id
+func (d *Dialer) Dial(ctx context.Context, rawurl string, header http.Header) (*Conn, *http.Response, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secure := false
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme, secure = "https", true
+	case "http", "https":
+		secure = u.Scheme == "https"
+	default:
+		return nil, nil, fmt.Errorf("websocket: bad scheme %q", u.Scheme)
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		if secure {
+			addr = net.JoinHostPort(u.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+
+	traceID := chain.TraceID(ctx)
+	connctx := chain.WithContext(context.Background(), traceID)
+
+	timeout := d.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	netDial := d.NetDial
+	if netDial == nil {
+		netDial = (&net.Dialer{}).DialContext
+	}
+	netConn, err := netDial(ctx, "tcp", addr)
+	if err != nil {
+		slog.ErrorContext(connctx, "WebSocket Dial error", "error", err, "url", rawurl)
+		return nil, nil, err
+	}
+
+	success := false
+	defer func() {
+		if !success {
+			netConn.Close()
+		}
+	}()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = netConn.SetDeadline(deadline)
+	}
+
+	if secure {
+		config := &tls.Config{}
+		if d.TLSConfig != nil {
+			config = d.TLSConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = u.Hostname()
+		}
+		tlsConn := tls.Client(netConn, config)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			slog.ErrorContext(connctx, "WebSocket TLS Handshake error", "error", err, "url", rawurl)
+			return nil, nil, err
+		}
+		netConn = tlsConn
+	}
+
+	var nonce [16]byte
+	_, _ = rand.Read(nonce[:])
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, values := range header {
+		req.Header[k] = values
+	}
+	if req.Header.Get(chain.XRquestID) == "" {
+		req.Header.Set(chain.XRquestID, traceID)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if len(d.Subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(d.Subprotocols, ", "))
+	}
+
+	if err = req.Write(netConn); err != nil {
+		slog.ErrorContext(connctx, "WebSocket handshake write error", "error", err, "url", rawurl)
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(netConn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		slog.ErrorContext(connctx, "WebSocket handshake read error", "error", err, "url", rawurl)
+		return nil, nil, err
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		!headerContains(resp.Header, "Upgrade", "websocket") ||
+		!headerContains(resp.Header, "Connection", "upgrade") ||
+		resp.Header.Get("Sec-WebSocket-Accept") != computeAccept(key) {
+		slog.ErrorContext(connctx, "WebSocket bad handshake", "status", resp.StatusCode, "url", rawurl)
+		return nil, resp, ErrBadHandshake
+	}
+
+	_ = netConn.SetDeadline(time.Time{})
+	success = true
+
+	c := newConn(connctx, netConn, br, false, &d.Options)
+	c.Subprotocol = resp.Header.Get("Sec-WebSocket-Protocol")
+	slog.InfoContext(connctx, "WebSocket open", "server", false, "url", rawurl, "subprotocol", c.Subprotocol)
+	return c, resp, nil
+}
+
+// headerContains header 逗号分隔的 token 中是否包含 value (忽略大小写)
+func headerContains(header http.Header, key, value string) bool {
+	for _, token := range headerTokens(header, key) {
+		if strings.EqualFold(token, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func headerTokens(header http.Header, key string) (tokens []string) {
+	for _, value := range header.Values(key) {
+		for token := range strings.SplitSeq(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return
+}
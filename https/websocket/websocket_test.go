@@ -0,0 +1,182 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wangzhione/sbp/chain"
+)
+
+// echoServer 原样回写收到的消息, 直到连接关闭
+func echoServer(t *testing.T, opts *Options) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, _ = chain.Request(r)
+
+		conn, err := Upgrade(w, r, opts)
+		if err != nil {
+			t.Log("Upgrade error", err)
+			return
+		}
+		defer conn.Close()
+
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Log("server ReadMessage", err)
+				return
+			}
+			if err = conn.WriteMessage(messageType, data); err != nil {
+				return
+			}
+		}
+	}))
+}
+
+func wsURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestEcho(t *testing.T) {
+	server := echoServer(t, nil)
+	defer server.Close()
+
+	ctx := chain.WithContext(context.Background(), "trace-ws")
+	conn, resp, err := Dial(ctx, wsURL(server), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get(chain.XRquestID) != "trace-ws" {
+		t.Fatalf("trace id not propagated: %q", resp.Header.Get(chain.XRquestID))
+	}
+
+	large := bytes.Repeat([]byte("x"), 70000) // 64 位长度帧
+	cases := []struct {
+		messageType int
+		data        []byte
+	}{
+		{TextMessage, []byte("hello")},
+		{BinaryMessage, []byte{0, 1, 2, 255}},
+		{TextMessage, bytes.Repeat([]byte("y"), 300)}, // 16 位长度帧
+		{BinaryMessage, large},
+	}
+	for _, c := range cases {
+		if err = conn.WriteMessage(c.messageType, c.data); err != nil {
+			t.Fatal(err)
+		}
+		messageType, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if messageType != c.messageType || !bytes.Equal(data, c.data) {
+			t.Fatalf("echo mismatch type %d len %d", messageType, len(data))
+		}
+	}
+
+	if err = conn.WriteJSON(map[string]int{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]int
+	if err = conn.ReadJSON(&got); err != nil || got["n"] != 1 {
+		t.Fatalf("json echo: %v %v", got, err)
+	}
+
+	// 关闭握手: 服务端收到 close 帧后回复, Close 不应该等到超时
+	begin := time.Now()
+	if err = conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Fatalf("close handshake too slow: %s", elapsed)
+	}
+	if err = conn.WriteMessage(TextMessage, []byte("after close")); err != ErrClosed {
+		t.Fatalf("write after close: %v", err)
+	}
+}
+
+func TestReadLimit(t *testing.T) {
+	server := echoServer(t, &Options{ReadLimit: 1024})
+	defer server.Close()
+
+	conn, _, err := Dial(context.Background(), wsURL(server), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err = conn.WriteMessage(BinaryMessage, make([]byte, 2048)); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = conn.ReadMessage()
+	if !IsCloseError(err, CloseMessageTooBig) {
+		t.Fatalf("expected CloseMessageTooBig, got %v", err)
+	}
+}
+
+func TestKeepalive(t *testing.T) {
+	pongs := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := Upgrade(w, r, &Options{PingInterval: 20 * time.Millisecond})
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		// 客户端在读循环里自动回复 pong, 服务端读超时为 2 * PingInterval, 能持续读到说明保活正常
+		_, _, err = conn.ReadMessage()
+		if err == nil {
+			pongs <- struct{}{}
+		}
+	}))
+	defer server.Close()
+
+	conn, _, err := Dial(context.Background(), wsURL(server), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	go func() {
+		time.Sleep(150 * time.Millisecond)
+		_ = conn.WriteMessage(TextMessage, []byte("still alive"))
+	}()
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-pongs:
+	case <-time.After(3 * time.Second):
+		t.Fatal("server did not stay alive")
+	}
+}
+
+func TestUpgradeRejected(t *testing.T) {
+	server := echoServer(t, nil)
+	defer server.Close()
+
+	// 普通 http 请求
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("plain http status: %d", resp.StatusCode)
+	}
+
+	// 跨站 Origin
+	header := http.Header{"Origin": {"https://evil.example.com"}}
+	_, resp, err = Dial(context.Background(), wsURL(server), header)
+	if err != ErrBadHandshake || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("cross origin: %v %v", err, resp)
+	}
+}